@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/hkdsun/simiload/grafana"
+	"github.com/hkdsun/simiload/platform"
+)
+
+var (
+	output = flag.String("output", "dashboard.json", "file the dashboard is written to")
+	title  = flag.String("title", "Simiload", "title of the dashboard")
+)
+
+// Generates the Grafana dashboard from the metric catalogue in platform
+func main() {
+	flag.Parse()
+
+	dashboard := grafana.NewDashboard(*title, platform.MetricService, platform.MetricCatalogue)
+
+	data, err := json.MarshalIndent(dashboard, "", "  ")
+	if err != nil {
+		log.WithError(err).Fatal("unable to encode dashboard")
+	}
+
+	if err := ioutil.WriteFile(*output, append(data, '\n'), 0644); err != nil {
+		log.WithError(err).Fatal("unable to write dashboard")
+	}
+}
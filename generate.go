@@ -5,100 +5,173 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
-	"time"
 
 	"github.com/hkdsun/simiload/load"
+	"github.com/hkdsun/simiload/load/agent"
+	"github.com/hkdsun/simiload/load/config"
+	"github.com/hkdsun/simiload/load/control"
 	log "github.com/sirupsen/logrus"
 )
 
-var loadsConfigFile = flag.String("config", "", "load config json file")
+var (
+	loadsConfigFile = flag.String("config", "", "load config json file")
+	replayFile      = flag.String("replay", "", "jsonl file of recorded requests to replay instead of a load config")
+	replaySpeed     = flag.Float64("speed", 1, "replay speed relative to the recorded pace")
+	resultsFile     = flag.String("results", "results.json", "file the per load results are written to")
+	validateOnly    = flag.Bool("validate", false, "only check the load config for errors")
+	numAgents       = flag.Int("agents", 0, "split the loads between this many generator processes")
+	agentMode       = flag.Bool("agent", false, "run as an agent of another generator, reading the job from stdin")
+	controlAddr     = flag.String("control", "", "address to serve the control API on, e.g. :9100. Keeps the generator running until interrupted")
+)
 
 func usage() {
 	fmt.Printf("Load generator tool")
 	fmt.Println()
 	fmt.Println("Usage: generate -config flash_sale.json <url>")
+	fmt.Println("       generate -replay requests.jsonl -speed 2 <url>")
+	fmt.Println("       generate -validate -config flash_sale.json")
+	fmt.Println("       generate -agents 4 -config flash_sale.json <url>")
+	fmt.Println("       generate -control :9100 [-config flash_sale.json] <url>")
 	fmt.Println()
 	flag.PrintDefaults()
 }
 
-func getLoadConfig() ([]*load.Load, error) {
+func main() {
+	flag.Parse()
 
-	jsonFile, err := os.Open(*loadsConfigFile)
-	if err != nil {
-		log.Fatal(err)
+	if *agentMode {
+		// Stdout carries the results back to the coordinator
+		log.SetOutput(os.Stderr)
+		if err := agent.Run(os.Stdin, os.Stdout); err != nil {
+			log.WithError(err).Fatal("agent failed")
+		}
+		return
+	}
+
+	if *replayFile != "" {
+		replay()
+		return
+	}
+
+	if *loadsConfigFile == "" && (*controlAddr == "" || *validateOnly) {
+		usage()
 		os.Exit(1)
 	}
-	defer jsonFile.Close()
 
-	byteValue, _ := ioutil.ReadAll(jsonFile)
+	var loads []*load.Load
+	if *loadsConfigFile != "" {
+		var err error
+		loads, err = config.ParseFile(*loadsConfigFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-	var loadsConfig []struct {
-		Path        string  `json:"path"`
-		StartAfter  string  `json:"start_after"`
-		Duration    string  `json:"duration"`
-		Concurrency int     `json:"concurrency"`
-		QPS         float64 `json:"qps"`
+	if *validateOnly {
+		fmt.Printf("%s: %d loads ok\n", *loadsConfigFile, len(loads))
+		return
 	}
 
-	err = json.Unmarshal(byteValue, &loadsConfig)
-	if err != nil {
-		return nil, err
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(1)
 	}
 
-	var loads []*load.Load = make([]*load.Load, len(loadsConfig))
+	if *numAgents > 0 {
+		distribute()
+		return
+	}
 
-	for i, l := range loadsConfig {
-		startAfter, err := time.ParseDuration(l.StartAfter)
-		if err != nil {
-			fmt.Printf("l.startAfter = %+v\n", l.StartAfter)
-			fmt.Printf("l = %+v\n", l)
-			return nil, err
-		}
+	gen := &load.Generator{
+		ServerURL:  flag.Arg(0),
+		Loads:      loads,
+		Persistent: *controlAddr != "",
+	}
 
-		duration, err := time.ParseDuration(l.Duration)
-		if err != nil {
-			fmt.Printf("l.duration = %+v\n", l.Duration)
-			return nil, err
-		}
+	if *controlAddr != "" {
+		go func() {
+			log.WithField("addr", *controlAddr).Info("Serving control API")
+			if err := http.ListenAndServe(*controlAddr, &control.Server{Generator: gen}); err != nil {
+				log.WithError(err).Fatal("control API failed")
+			}
+		}()
+	}
 
-		if l.Concurrency == 0 {
-			l.Concurrency = 4
-		}
+	gen.Run()
 
-		if l.QPS == 0 {
-			l.QPS = 10
-		}
+	reportResults(gen.Results())
+}
 
-		loads[i] = &load.Load{
-			Path:        l.Path,
-			StartAfter:  startAfter,
-			Duration:    duration,
-			Concurrency: l.Concurrency,
-			QPS:         l.QPS,
-		}
+func reportResults(results []*load.LoadResult) {
+	fmt.Println()
+	load.WriteResults(os.Stdout, results)
+
+	if *resultsFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		log.WithError(err).Error("unable to encode results")
+		return
 	}
 
-	return loads, nil
+	if err := ioutil.WriteFile(*resultsFile, data, 0644); err != nil {
+		log.WithError(err).Error("unable to write results")
+		return
+	}
+
+	log.WithField("file", *resultsFile).Info("Wrote load results")
 }
 
-func main() {
-	flag.Parse()
+func distribute() {
+	scenario, err := ioutil.ReadFile(*loadsConfigFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		log.WithError(err).Fatal("unable to locate the generator executable")
+	}
 
-	if *loadsConfigFile == "" {
+	coordinator := &agent.Coordinator{
+		Executable: executable,
+		Args:       []string{"-agent"},
+		Agents:     *numAgents,
+		ServerURL:  flag.Arg(0),
+		File:       *loadsConfigFile,
+		Scenario:   scenario,
+	}
+
+	results, err := coordinator.Run()
+	if err != nil {
+		log.WithError(err).Fatal("distributed load failed")
+	}
+
+	reportResults(results)
+}
+
+func replay() {
+	if flag.NArg() < 1 {
 		usage()
 		os.Exit(1)
 	}
 
-	loads, err := getLoadConfig()
+	records, err := load.ReadRecordsFile(*replayFile)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	gen := &load.Generator{
-		ServerURL: flag.Args()[0],
-		Loads:     loads,
+	replayer := &load.Replayer{
+		ServerURL: flag.Arg(0),
+		Records:   records,
+		Speed:     *replaySpeed,
 	}
-	gen.Run()
+	replayer.Run()
 }
@@ -0,0 +1,348 @@
+// Package grafana builds the Grafana dashboard of the simulator from
+// platform.MetricCatalogue, so that the dashboard follows the metrics as they
+// change. Run `go run dashboard.go` to regenerate dashboard.json.
+package grafana
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hkdsun/simiload/platform"
+)
+
+const (
+	schemaVersion = 16
+	panelWidth    = 8
+	panelHeight   = 8
+	gridWidth     = 24
+
+	datasourceVariable = "$datasource"
+	shopVariable       = "$shop"
+)
+
+type Dashboard struct {
+	Title         string      `json:"title"`
+	UID           string      `json:"uid"`
+	Editable      bool        `json:"editable"`
+	Refresh       string      `json:"refresh"`
+	SchemaVersion int         `json:"schemaVersion"`
+	Style         string      `json:"style"`
+	Tags          []string    `json:"tags"`
+	Time          TimeRange   `json:"time"`
+	Templating    Templating  `json:"templating"`
+	Annotations   Annotations `json:"annotations"`
+	Panels        []Panel     `json:"panels"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+type Variable struct {
+	Name       string   `json:"name"`
+	Label      string   `json:"label"`
+	Type       string   `json:"type"`
+	Query      string   `json:"query"`
+	Datasource string   `json:"datasource,omitempty"`
+	Refresh    int      `json:"refresh"`
+	Multi      bool     `json:"multi"`
+	IncludeAll bool     `json:"includeAll"`
+	AllValue   string   `json:"allValue,omitempty"`
+	Sort       int      `json:"sort"`
+	Current    Current  `json:"current"`
+	Options    []string `json:"options"`
+}
+
+type Current struct {
+	Text  string `json:"text,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+type Annotations struct {
+	List []Annotation `json:"list"`
+}
+
+type Annotation struct {
+	BuiltIn    int    `json:"builtIn"`
+	Datasource string `json:"datasource"`
+	Enable     bool   `json:"enable"`
+	Hide       bool   `json:"hide"`
+	IconColor  string `json:"iconColor"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// A graph panel, or a row when Type is "row"
+type Panel struct {
+	ID          int      `json:"id"`
+	Type        string   `json:"type"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Datasource  string   `json:"datasource,omitempty"`
+	GridPos     GridPos  `json:"gridPos"`
+	Interval    string   `json:"interval,omitempty"`
+	Targets     []Target `json:"targets,omitempty"`
+	Lines       bool     `json:"lines,omitempty"`
+	Linewidth   int      `json:"linewidth,omitempty"`
+	Fill        int      `json:"fill,omitempty"`
+	Legend      *Legend  `json:"legend,omitempty"`
+	Tooltip     *Tooltip `json:"tooltip,omitempty"`
+	Xaxis       *Axis    `json:"xaxis,omitempty"`
+	Yaxes       []Axis   `json:"yaxes,omitempty"`
+	Collapsed   bool     `json:"collapsed"`
+	Panels      []Panel  `json:"panels,omitempty"`
+}
+
+type Target struct {
+	Expr         string `json:"expr"`
+	Format       string `json:"format"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	RefID        string `json:"refId"`
+}
+
+type Legend struct {
+	Show    bool `json:"show"`
+	Values  bool `json:"values"`
+	Current bool `json:"current"`
+	Max     bool `json:"max"`
+}
+
+type Tooltip struct {
+	Shared    bool   `json:"shared"`
+	Sort      int    `json:"sort"`
+	ValueType string `json:"value_type"`
+}
+
+type Axis struct {
+	Format  string `json:"format,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	LogBase int    `json:"logBase,omitempty"`
+	Show    bool   `json:"show"`
+}
+
+// One row per group of metrics in the catalogue, in the order the groups
+// first appear, and one panel per metric family. Service is the prefix
+// the simulator's metrics are exported with.
+func NewDashboard(title, service string, catalogue []platform.Metric) Dashboard {
+	d := Dashboard{
+		Title:         title,
+		UID:           "simiload",
+		Editable:      true,
+		Refresh:       "5s",
+		SchemaVersion: schemaVersion,
+		Style:         "dark",
+		Tags:          []string{"simiload"},
+		Time:          TimeRange{From: "now-15m", To: "now"},
+		Templating:    Templating{List: variables(service, catalogue)},
+		Annotations: Annotations{List: []Annotation{{
+			BuiltIn:    1,
+			Datasource: "-- Grafana --",
+			Enable:     true,
+			Hide:       true,
+			IconColor:  "rgba(0, 211, 255, 1)",
+			Name:       "Annotations & Alerts",
+			Type:       "dashboard",
+		}}},
+	}
+
+	var rows []string
+	byRow := make(map[string][]platform.Metric)
+	for _, metric := range catalogue {
+		if _, ok := byRow[metric.Row]; !ok {
+			rows = append(rows, metric.Row)
+		}
+		byRow[metric.Row] = append(byRow[metric.Row], metric)
+	}
+
+	id := 1
+	y := 0
+	for _, row := range rows {
+		d.Panels = append(d.Panels, Panel{
+			ID:      id,
+			Type:    "row",
+			Title:   row,
+			GridPos: GridPos{H: 1, W: gridWidth, X: 0, Y: y},
+		})
+		id++
+		y++
+
+		for i, metric := range byRow[row] {
+			x := (i * panelWidth) % gridWidth
+			if i > 0 && x == 0 {
+				y += panelHeight
+			}
+
+			panel := newPanel(service, metric)
+			panel.ID = id
+			panel.GridPos = GridPos{H: panelHeight, W: panelWidth, X: x, Y: y}
+			d.Panels = append(d.Panels, panel)
+			id++
+		}
+		y += panelHeight
+	}
+
+	return d
+}
+
+// The data source, and the shops of the first metric labelled by shop
+func variables(service string, catalogue []platform.Metric) []Variable {
+	vars := []Variable{{
+		Name:    "datasource",
+		Label:   "Data source",
+		Type:    "datasource",
+		Query:   "prometheus",
+		Refresh: 1,
+		Options: []string{},
+	}}
+
+	for _, metric := range catalogue {
+		if !hasLabel(metric, "shop_id") {
+			continue
+		}
+
+		series := metric.PrometheusName(service)
+		if metric.Kind == platform.MetricHistogram {
+			series += "_bucket"
+		}
+		vars = append(vars, Variable{
+			Name:       "shop",
+			Label:      "Shop",
+			Type:       "query",
+			Query:      fmt.Sprintf("label_values(%s, shop_id)", series),
+			Datasource: datasourceVariable,
+			Refresh:    2,
+			Multi:      true,
+			IncludeAll: true,
+			AllValue:   ".*",
+			Sort:       3,
+			Current:    Current{Text: "All", Value: "$__all"},
+			Options:    []string{},
+		})
+		break
+	}
+
+	return vars
+}
+
+func newPanel(service string, metric platform.Metric) Panel {
+	unit := metric.Unit
+	if unit == "" {
+		unit = "short"
+	}
+
+	return Panel{
+		Type:        "graph",
+		Title:       metric.Name,
+		Description: metric.Help,
+		Datasource:  datasourceVariable,
+		Interval:    "2s",
+		Targets:     targets(service, metric),
+		Lines:       true,
+		Linewidth:   1,
+		Fill:        1,
+		Legend:      &Legend{Show: true},
+		Tooltip:     &Tooltip{Shared: true, ValueType: "individual"},
+		Xaxis:       &Axis{Mode: "time", Show: true},
+		Yaxes: []Axis{
+			{Format: unit, LogBase: 1, Show: true},
+			{Format: "short", LogBase: 1, Show: false},
+		},
+	}
+}
+
+// Counters are graphed as rates and histograms as their 50th, 95th and 99th
+// percentiles. Series are summed over client ids, and histograms over shops
+// as well, which keeps the number of lines readable.
+func targets(service string, metric platform.Metric) []Target {
+	name := metric.PrometheusName(service)
+	selector := ""
+	if hasLabel(metric, "shop_id") {
+		selector = fmt.Sprintf(`{shop_id=~"%s"}`, shopVariable)
+	}
+
+	switch metric.Kind {
+	case platform.MetricCounter:
+		by := groupBy(metric, "client_id")
+		return []Target{
+			newTarget(0, fmt.Sprintf("sum%s(rate(%s%s[$__interval]))", byClause(by), name, selector), legend(by)),
+		}
+	case platform.MetricHistogram:
+		by := groupBy(metric, "client_id", "shop_id")
+		var result []Target
+		for i, p := range []struct{ name, quantile string }{{"p50", "0.5"}, {"p95", "0.95"}, {"p99", "0.99"}} {
+			expr := fmt.Sprintf("histogram_quantile(%s, sum by (%s) (rate(%s_bucket%s[$__interval])))",
+				p.quantile, strings.Join(append([]string{"le"}, by...), ", "), name, selector)
+			result = append(result, newTarget(i, expr, strings.TrimSpace(p.name+" "+legend(by))))
+		}
+		return result
+	default:
+		if metric.Prefixed {
+			// Also matches the keys of chain stages and shadows
+			key := strings.TrimPrefix(metric.Name, "controller.")
+			expr := fmt.Sprintf(`{__name__=~"%s_controller_(.+_)?%s"}`, service, key)
+			return []Target{newTarget(0, expr, "{{__name__}} "+legend(metric.Labels))}
+		}
+		return []Target{newTarget(0, name+selector, legend(metric.Labels))}
+	}
+}
+
+func newTarget(i int, expr, legendFormat string) Target {
+	return Target{
+		Expr:         expr,
+		Format:       "time_series",
+		LegendFormat: legendFormat,
+		RefID:        string(rune('A' + i)),
+	}
+}
+
+func hasLabel(metric platform.Metric, label string) bool {
+	for _, l := range metric.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func groupBy(metric platform.Metric, without ...string) []string {
+	var by []string
+	for _, label := range metric.Labels {
+		keep := true
+		for _, w := range without {
+			if label == w {
+				keep = false
+			}
+		}
+		if keep {
+			by = append(by, label)
+		}
+	}
+	return by
+}
+
+func byClause(by []string) string {
+	if len(by) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" by (%s) ", strings.Join(by, ", "))
+}
+
+func legend(labels []string) string {
+	var parts []string
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf("%s={{%s}}", label, label))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,92 @@
+// Package agent spreads a scenario over several generator processes, for
+// loads that a single process cannot offer. The coordinator starts each agent
+// as a child process, hands it the scenario on stdin and reads its results
+// from stdout. Every agent runs its share of every load: the load's
+// concurrency, and with it the offered rate, is divided between the agents.
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/hkdsun/simiload/load"
+	"github.com/hkdsun/simiload/load/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// What an agent is asked to run
+type Job struct {
+	File      string          `json:"file"` // only used in error messages
+	Scenario  json.RawMessage `json:"scenario"`
+	ServerURL string          `json:"server_url"`
+	Agent     int             `json:"agent"`
+	Agents    int             `json:"agents"`
+	StartAt   time.Time       `json:"start_at"`
+}
+
+// Reads a Job, runs this agent's share of it once StartAt is reached and
+// writes the results as JSON
+func Run(in io.Reader, out io.Writer) error {
+	var job Job
+	if err := json.NewDecoder(in).Decode(&job); err != nil {
+		return err
+	}
+
+	loads, err := config.Parse(job.File, job.Scenario)
+	if err != nil {
+		return err
+	}
+
+	// Delaying every load keeps agents that started at different times in
+	// step, and lets an interrupt cut the wait short
+	delay := time.Until(job.StartAt)
+	if delay < 0 {
+		delay = 0
+	}
+
+	for i, l := range loads {
+		share := split(*l, job.Agent, job.Agents)
+		share.StartAfter += delay
+		loads[i] = &share
+	}
+
+	gen := &load.Generator{
+		ServerURL: job.ServerURL,
+		Loads:     loads,
+	}
+
+	log.WithField("agent", job.Agent).WithField("start_at", job.StartAt).Info("Running share of scenario")
+	gen.Run()
+
+	return json.NewEncoder(out).Encode(gen.Results())
+}
+
+// The agent's share of a load. Workers are dealt out one at a time, so an
+// agent may get none of a small load; it still runs it to keep load names
+// and start times aligned across agents.
+func split(l load.Load, agent, agents int) load.Load {
+	l.Concurrency = share(l.Concurrency, agent, agents)
+
+	if l.MaxInFlight > 0 {
+		l.MaxInFlight = share(l.MaxInFlight, agent, agents)
+		if l.MaxInFlight == 0 {
+			l.MaxInFlight = 1
+		}
+	}
+
+	// Agents with the same seed would send identical request sequences
+	if l.Seed != 0 {
+		l.Seed += int64(agent)
+	}
+
+	return l
+}
+
+func share(total, agent, agents int) int {
+	n := total / agents
+	if agent < total%agents {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/hkdsun/simiload/load"
+	log "github.com/sirupsen/logrus"
+)
+
+// Time given to the agents to start up before the loads begin
+const startDelay = 2 * time.Second
+
+// Runs a scenario on Agents child processes and merges their results.
+// Executable is started with Args and must serve Run on its stdin/stdout.
+type Coordinator struct {
+	Executable string
+	Args       []string
+	Agents     int
+	ServerURL  string
+	File       string
+	Scenario   []byte
+}
+
+type agentProcess struct {
+	index   int
+	cmd     *exec.Cmd
+	stdout  io.Reader
+	results []*load.LoadResult
+	err     error
+}
+
+func (c *Coordinator) Run() ([]*load.LoadResult, error) {
+	startAt := time.Now().Add(startDelay)
+
+	var agents []*agentProcess
+	for i := 0; i < c.Agents; i++ {
+		agent, err := c.start(i, startAt)
+		if err != nil {
+			for _, a := range agents {
+				a.cmd.Process.Kill()
+			}
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+
+	// Agents stop their loads and report what they have so far
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		for range sigs {
+			for _, a := range agents {
+				a.cmd.Process.Signal(os.Interrupt)
+			}
+		}
+	}()
+
+	log.WithField("agents", c.Agents).WithField("start_at", startAt).Info("Started agents")
+
+	wg := &sync.WaitGroup{}
+	for _, a := range agents {
+		wg.Add(1)
+		go func(a *agentProcess) {
+			defer wg.Done()
+			a.wait()
+		}(a)
+	}
+	wg.Wait()
+
+	var results [][]*load.LoadResult
+	for _, a := range agents {
+		if a.err != nil {
+			return nil, fmt.Errorf("agent %d: %v", a.index, a.err)
+		}
+		results = append(results, a.results)
+	}
+
+	return merge(results), nil
+}
+
+func (c *Coordinator) start(index int, startAt time.Time) (*agentProcess, error) {
+	job, err := json.Marshal(Job{
+		File:      c.File,
+		Scenario:  c.Scenario,
+		ServerURL: c.ServerURL,
+		Agent:     index,
+		Agents:    c.Agents,
+		StartAt:   startAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(c.Executable, c.Args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer stdin.Close()
+		stdin.Write(job)
+	}()
+
+	return &agentProcess{index: index, cmd: cmd, stdout: stdout}, nil
+}
+
+// The results must be read before waiting, which closes stdout
+func (a *agentProcess) wait() {
+	decodeErr := json.NewDecoder(a.stdout).Decode(&a.results)
+
+	if err := a.cmd.Wait(); err != nil {
+		a.err = err
+	} else if decodeErr != nil {
+		a.err = fmt.Errorf("unable to read results: %v", decodeErr)
+	}
+}
+
+// Combines the results of every agent by load name, in start order
+func merge(results [][]*load.LoadResult) []*load.LoadResult {
+	var merged []*load.LoadResult
+	byName := make(map[string]*load.LoadResult)
+
+	for _, agentResults := range results {
+		for _, r := range agentResults {
+			m, ok := byName[r.Name]
+			if !ok {
+				m = load.NewLoadResult(r.Name)
+				byName[r.Name] = m
+				merged = append(merged, m)
+			}
+			m.Merge(r)
+		}
+	}
+
+	return merged
+}
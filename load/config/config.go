@@ -0,0 +1,424 @@
+// Package config reads and validates the JSON scenario files consumed by the
+// load generator. A scenario is a list of loads:
+//
+//	[
+//	  {
+//	    "name": "checkout",
+//	    "path": "shop/1/a",
+//	    "method": "POST",
+//	    "headers": {"X-Client": "{{.ClientId}}"},
+//	    "body": "{\"shop\": {{.ShopId}}}",
+//	    "content_type": "application/json",
+//	    "target": "http://localhost:8090",
+//	    "start_after": "30s",
+//	    "duration": "4m",
+//	    "concurrency": 10,
+//	    "qps": 10
+//	  }
+//	]
+//
+// Header values and the body are Go templates over load.RequestData. Every
+// problem in a file is reported with its line and column.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hkdsun/simiload/load"
+)
+
+type hotShopConfig struct {
+	ShopId     int     `json:"shop_id"`
+	Share      float64 `json:"share"`
+	StartAfter string  `json:"start_after"`
+	Duration   string  `json:"duration"`
+}
+
+type populationConfig struct {
+	Shops      int             `json:"shops"`
+	Clients    int             `json:"clients"`
+	ShopSkew   float64         `json:"shop_skew"`
+	ClientSkew float64         `json:"client_skew"`
+	HotShops   []hotShopConfig `json:"hot_shops"`
+}
+
+type profilePointConfig struct {
+	At  string  `json:"at"`
+	QPS float64 `json:"qps"`
+}
+
+type profileConfig struct {
+	Shape     string               `json:"shape"`
+	From      float64              `json:"from"`
+	To        float64              `json:"to"`
+	Base      float64              `json:"base"`
+	Peak      float64              `json:"peak"`
+	Amplitude float64              `json:"amplitude"`
+	Over      string               `json:"over"`
+	Period    string               `json:"period"`
+	Points    []profilePointConfig `json:"points"`
+}
+
+type loadConfig struct {
+	Name        string            `json:"name"`
+	Path        string            `json:"path"`
+	Target      string            `json:"target"`
+	Method      string            `json:"method"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	ContentType string            `json:"content_type"`
+	Traceparent bool              `json:"traceparent"`
+	StartAfter  string            `json:"start_after"`
+	Duration    string            `json:"duration"`
+	Concurrency int               `json:"concurrency"`
+	QPS         float64           `json:"qps"`
+	Profile     *profileConfig    `json:"profile"`
+	Arrival     string            `json:"arrival"`
+	MaxInFlight int               `json:"max_in_flight"`
+	Seed        int64             `json:"seed"`
+	Population  *populationConfig `json:"population"`
+}
+
+var methods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+	"OPTIONS": true,
+}
+
+func ParseFile(path string) ([]*load.Load, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(path, data)
+}
+
+// Parses a scenario. The file name is only used in error messages.
+func Parse(file string, data []byte) ([]*load.Load, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, decodeError(file, data, 0, err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, newError(file, data, 0, "", "expected a list of loads")
+	}
+
+	var loads []*load.Load
+	var errs Errors
+	names := make(map[string]bool)
+
+	for i := 0; dec.More(); i++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, decodeError(file, data, 0, err)
+		}
+
+		e := &element{
+			file:   file,
+			data:   data,
+			raw:    raw,
+			start:  dec.InputOffset() - int64(len(raw)),
+			prefix: fmt.Sprintf("loads[%d]", i),
+		}
+
+		l := e.parse()
+		if l != nil && l.Name != "" {
+			if names[l.Name] {
+				e.errorf([]string{"name"}, "duplicate load name %q", l.Name)
+			}
+			names[l.Name] = true
+		}
+
+		errs = append(errs, e.errs...)
+		loads = append(loads, l)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, decodeError(file, data, 0, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs.sorted()
+	}
+	if len(loads) == 0 {
+		return nil, newError(file, data, 0, "", "no loads defined")
+	}
+
+	return loads, nil
+}
+
+// Parses a single load object, as accepted by the generator's control API
+func ParseLoad(file string, data []byte) (*load.Load, error) {
+	e := &element{
+		file:   file,
+		data:   data,
+		raw:    data,
+		prefix: "load",
+	}
+
+	l := e.parse()
+	if len(e.errs) > 0 {
+		return nil, e.errs.sorted()
+	}
+
+	return l, nil
+}
+
+func newError(file string, data []byte, offset int64, field, msg string) *Error {
+	line, column := position(data, offset)
+	return &Error{
+		File:   file,
+		Line:   line,
+		Column: column,
+		Field:  field,
+		Msg:    msg,
+	}
+}
+
+// Translates encoding/json errors, whose offsets are relative to base
+func decodeError(file string, data []byte, base int64, err error) *Error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return newError(file, data, base+e.Offset, "", e.Error())
+	case *json.UnmarshalTypeError:
+		return newError(file, data, base+e.Offset, e.Field, fmt.Sprintf("expected %s, got %s", e.Type, e.Value))
+	}
+
+	return newError(file, data, base, "", err.Error())
+}
+
+// A single load of a scenario being parsed
+type element struct {
+	file   string
+	data   []byte
+	raw    []byte
+	start  int64
+	prefix string
+	errs   Errors
+}
+
+func (e *element) errorf(keys []string, format string, args ...interface{}) {
+	offset := e.start + locate(e.raw, keys...)
+	e.errs = append(e.errs, newError(e.file, e.data, offset, fieldPath(e.prefix, keys), fmt.Sprintf(format, args...)))
+}
+
+func (e *element) duration(value string, keys ...string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		e.errorf(keys, "invalid duration %q", value)
+		return 0
+	}
+	if d < 0 {
+		e.errorf(keys, "duration must not be negative")
+	}
+	return d
+}
+
+func (e *element) parse() *load.Load {
+	var c loadConfig
+
+	dec := json.NewDecoder(bytes.NewReader(e.raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		// Other errors can precede an unknown field in the document
+		if strings.HasPrefix(err.Error(), "json: unknown field") {
+			if keys, offset, ok := locateUnknown(e.raw, reflect.TypeOf(c)); ok {
+				e.errs = append(e.errs, newError(e.file, e.data, e.start+offset, fieldPath(e.prefix, keys), "unknown field"))
+				return nil
+			}
+		}
+		decodeErr := decodeError(e.file, e.data, e.start, err)
+		if decodeErr.Field != "" {
+			decodeErr.Field = e.prefix + "." + decodeErr.Field
+		} else {
+			decodeErr.Field = e.prefix
+		}
+		e.errs = append(e.errs, decodeErr)
+		return nil
+	}
+
+	l := &load.Load{
+		Name:        c.Name,
+		Path:        strings.TrimPrefix(c.Path, "/"),
+		Target:      strings.TrimSuffix(c.Target, "/"),
+		Method:      strings.ToUpper(c.Method),
+		Headers:     c.Headers,
+		ContentType: c.ContentType,
+		Traceparent: c.Traceparent,
+		StartAfter:  e.duration(c.StartAfter, "start_after"),
+		Duration:    e.duration(c.Duration, "duration"),
+		Concurrency: c.Concurrency,
+		QPS:         c.QPS,
+		Arrival:     c.Arrival,
+		MaxInFlight: c.MaxInFlight,
+		Seed:        c.Seed,
+	}
+
+	if c.Body != "" {
+		l.Body = []byte(c.Body)
+		if _, err := load.ParseTemplate("body", c.Body); err != nil {
+			e.errorf([]string{"body"}, "invalid template: %v", err)
+		}
+	}
+
+	for name, value := range c.Headers {
+		if _, err := load.ParseTemplate(name, value); err != nil {
+			e.errorf([]string{"headers", name}, "invalid template: %v", err)
+		}
+	}
+
+	if l.Method == "" {
+		l.Method = "GET"
+	} else if !methods[l.Method] {
+		e.errorf([]string{"method"}, "unsupported HTTP method %q", c.Method)
+	}
+
+	if c.Target != "" {
+		target, err := url.Parse(c.Target)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			e.errorf([]string{"target"}, "target must be an absolute http(s) URL")
+		}
+	}
+
+	if c.Path == "" && c.Population == nil {
+		e.errorf(nil, "either path or population is required")
+	}
+	if c.Path != "" && c.Population != nil {
+		e.errorf([]string{"population"}, "population replaces path, only one can be given")
+	}
+
+	if c.Concurrency < 1 {
+		e.errorf([]string{"concurrency"}, "concurrency must be at least 1")
+	}
+
+	switch {
+	case c.Profile == nil && c.QPS <= 0:
+		e.errorf([]string{"qps"}, "qps must be positive unless a profile is given")
+	case c.Profile != nil && c.QPS != 0:
+		e.errorf([]string{"qps"}, "qps is ignored when a profile is given")
+	case c.Profile != nil:
+		l.Profile = e.parseProfile(c.Profile)
+	}
+
+	switch c.Arrival {
+	case "", load.ArrivalClosed, load.ArrivalPoisson, load.ArrivalUniform:
+	default:
+		e.errorf([]string{"arrival"}, "arrival %q not recognized, use closed, poisson or uniform", c.Arrival)
+	}
+
+	if c.MaxInFlight < 0 {
+		e.errorf([]string{"max_in_flight"}, "max_in_flight must not be negative")
+	}
+
+	if c.Population != nil {
+		l.Population = e.parsePopulation(c.Population)
+	}
+
+	return l
+}
+
+func (e *element) parsePopulation(p *populationConfig) *load.Population {
+	if p.Shops < 1 {
+		e.errorf([]string{"population", "shops"}, "population needs at least one shop")
+	}
+	if p.Clients < 1 {
+		e.errorf([]string{"population", "clients"}, "population needs at least one client")
+	}
+	if p.ShopSkew <= 1 {
+		e.errorf([]string{"population", "shop_skew"}, "skew must be greater than 1")
+	}
+	if p.ClientSkew <= 1 {
+		e.errorf([]string{"population", "client_skew"}, "skew must be greater than 1")
+	}
+
+	population := &load.Population{
+		Shops:      p.Shops,
+		Clients:    p.Clients,
+		ShopSkew:   p.ShopSkew,
+		ClientSkew: p.ClientSkew,
+	}
+
+	share := 0.0
+	for i, hot := range p.HotShops {
+		index := strconv.Itoa(i)
+		if hot.Share <= 0 || hot.Share > 1 {
+			e.errorf([]string{"population", "hot_shops", index, "share"}, "hot shop %d needs a share between 0 and 1", hot.ShopId)
+		}
+		share += hot.Share
+
+		population.HotShops = append(population.HotShops, load.HotShop{
+			ShopId:     hot.ShopId,
+			Share:      hot.Share,
+			StartAfter: e.duration(hot.StartAfter, "population", "hot_shops", index, "start_after"),
+			Duration:   e.duration(hot.Duration, "population", "hot_shops", index, "duration"),
+		})
+	}
+
+	if share > 1 {
+		e.errorf([]string{"population", "hot_shops"}, "hot shop shares add up to more than 1")
+	}
+
+	return population
+}
+
+func (e *element) parseProfile(p *profileConfig) load.Profile {
+	positive := func(value, key string) time.Duration {
+		d := e.duration(value, "profile", key)
+		if d == 0 {
+			e.errorf([]string{"profile", key}, "%s profile needs a positive %s duration", p.Shape, key)
+		}
+		return d
+	}
+
+	switch p.Shape {
+	case "ramp":
+		return load.RampProfile{From: p.From, To: p.To, Over: positive(p.Over, "over")}
+	case "surge":
+		return load.SurgeProfile{Base: p.Base, Peak: p.Peak, Over: positive(p.Over, "over")}
+	case "sine":
+		return load.SineProfile{Base: p.Base, Amplitude: p.Amplitude, Period: positive(p.Period, "period")}
+	case "step", "piecewise":
+		if len(p.Points) == 0 {
+			e.errorf([]string{"profile", "points"}, "%s profile needs at least one point", p.Shape)
+		}
+
+		points := make([]load.ProfilePoint, len(p.Points))
+		for i, point := range p.Points {
+			index := strconv.Itoa(i)
+			points[i] = load.ProfilePoint{
+				At:  e.duration(point.At, "profile", "points", index, "at"),
+				QPS: point.QPS,
+			}
+			if i > 0 && points[i].At <= points[i-1].At {
+				e.errorf([]string{"profile", "points", index, "at"}, "point %d is not after the previous one", i)
+			}
+		}
+
+		if p.Shape == "step" {
+			return load.StepProfile{Steps: points}
+		}
+		return load.PiecewiseProfile{Points: points}
+	default:
+		e.errorf([]string{"profile", "shape"}, "profile shape %q not recognized, use ramp, surge, sine, step or piecewise", p.Shape)
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseLocatesErrors(t *testing.T) {
+	data := []byte(`[
+  {"name": "a", "path": "x", "concurrency": 1, "qps": 1},
+  {"name": "b", "path": "x", "concurrency": 0, "qps": 1},
+  {"name": "c", "concurrency": 1,
+   "profile": {"shape": "step", "points": [{"at": "1s", "qps": 1}, {"at": "bad", "qps": 2}]},
+   "population": {"shops": 2, "clients": 2, "shop_skew": 2, "client_skew": 2}}
+]`)
+
+	_, err := Parse("scenario.json", data)
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("got %v, want Errors", err)
+	}
+
+	want := []struct {
+		line, column int
+		field        string
+	}{
+		{3, 45, "loads[1].concurrency"},
+		{5, 75, "loads[2].profile.points[1].at"},
+	}
+
+	found := make(map[string]*Error)
+	for _, e := range errs {
+		found[e.Field] = e
+	}
+	for _, w := range want {
+		e, ok := found[w.field]
+		if !ok {
+			t.Errorf("no error for %s in %v", w.field, errs)
+			continue
+		}
+		if e.Line != w.line || e.Column != w.column {
+			t.Errorf("%s reported at %d:%d, want %d:%d", w.field, e.Line, e.Column, w.line, w.column)
+		}
+	}
+}
+
+func TestParseLocatesUnknownFields(t *testing.T) {
+	data := []byte(`[
+  {"name": "a", "path": "x", "concurrency": 1, "qps": 1},
+  {"name": "b", "path": "x", "concurrency": 1, "qps": 1, "bogus": true}
+]`)
+
+	_, err := Parse("scenario.json", data)
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("got %v, want a single error", err)
+	}
+	if e := errs[0]; e.Field != "loads[1].bogus" || e.Line != 3 || e.Column != 67 {
+		t.Errorf("got %v, want loads[1].bogus at 3:67", e)
+	}
+}
+
+func TestParseLocatesNestedUnknownFields(t *testing.T) {
+	data := []byte(`[
+  {
+    "name": "a",
+    "qps": 1,
+    "path": "x",
+    "concurrency": 1,
+    "profile": {
+      "shape": "ramp", "qps": 2
+    }
+  }
+]`)
+
+	_, err := Parse("x.json", data)
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("got %v, want a single error", err)
+	}
+	if e := errs[0]; e.Field != "loads[0].profile.qps" || e.Line != 8 || e.Column != 31 {
+		t.Errorf("got %v, want loads[0].profile.qps at 8:31", e)
+	}
+}
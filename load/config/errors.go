@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A problem with a load config, located at the line and column of the
+// offending value where possible
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Field  string // e.g. loads[2].profile.over
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	location := fmt.Sprintf("%s:%d:%d", e.File, e.Line, e.Column)
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", location, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, e.Field, e.Msg)
+}
+
+// Every problem found in a config, so that they can be fixed in one go
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e Errors) sorted() Errors {
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].Line != e[j].Line {
+			return e[i].Line < e[j].Line
+		}
+		return e[i].Column < e[j].Column
+	})
+	return e
+}
+
+// 1-based line and column of a byte offset
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// Offset of the value at the given path within a JSON document, or of the
+// innermost value that could be found. Keys are object keys, or the indices
+// of array elements.
+func locate(raw []byte, keys ...string) int64 {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+
+	var offset int64
+	for _, key := range keys {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		delim, ok := tok.(json.Delim)
+		if !ok || (delim != '{' && delim != '[') {
+			break
+		}
+
+		found := false
+		for i := 0; dec.More() && !found; i++ {
+			if delim == '{' {
+				name, err := dec.Token()
+				if err != nil {
+					return offset
+				}
+				found = name == key
+			} else {
+				found = strconv.Itoa(i) == key
+			}
+
+			if !found {
+				var value json.RawMessage
+				if err := dec.Decode(&value); err != nil {
+					return offset
+				}
+			}
+		}
+		if !found {
+			break
+		}
+		offset = valueStart(raw, dec.InputOffset())
+	}
+	return offset
+}
+
+// Skips the separators between the decoder's offset and the next value
+func valueStart(raw []byte, offset int64) int64 {
+	for offset < int64(len(raw)) && strings.IndexByte(" \t\r\n:,", raw[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// Path and offset of the value of the first key, in document order, that
+// typ does not declare. encoding/json names unknown fields without saying
+// where they are nested.
+func locateUnknown(raw []byte, typ reflect.Type) ([]string, int64, bool) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	return walkUnknown(raw, dec, typ, nil)
+}
+
+func walkUnknown(raw []byte, dec *json.Decoder, typ reflect.Type, path []string) ([]string, int64, bool) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, 0, false
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil, 0, false
+	}
+
+	for i := 0; dec.More(); i++ {
+		key := strconv.Itoa(i)
+		valueType := typ
+		if delim == '{' {
+			name, err := dec.Token()
+			if err != nil {
+				return nil, 0, false
+			}
+			key, _ = name.(string)
+
+			switch typ.Kind() {
+			case reflect.Struct:
+				field, ok := jsonField(typ, key)
+				if !ok {
+					return append(path, key), valueStart(raw, dec.InputOffset()), true
+				}
+				valueType = field.Type
+			case reflect.Map:
+				valueType = typ.Elem()
+			}
+		} else if typ.Kind() == reflect.Slice {
+			valueType = typ.Elem()
+		}
+
+		child := append(append([]string(nil), path...), key)
+		if found, offset, ok := walkUnknown(raw, dec, valueType, child); ok {
+			return found, offset, true
+		}
+	}
+
+	dec.Token()
+	return nil, 0, false
+}
+
+func jsonField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if strings.Split(field.Tag.Get("json"), ",")[0] == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// The field at keys within the element named by prefix, e.g.
+// loads[0].profile.points[2].at
+func fieldPath(prefix string, keys []string) string {
+	field := prefix
+	for _, key := range keys {
+		if _, err := strconv.Atoi(key); err == nil {
+			field += "[" + key + "]"
+		} else {
+			field += "." + key
+		}
+	}
+	return field
+}
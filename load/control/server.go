@@ -0,0 +1,144 @@
+// Package control exposes a running load generator over HTTP:
+//
+//	GET    /loads          status of every load that has started
+//	POST   /loads          start a load, given as one entry of a scenario file
+//	PATCH  /loads/<name>   change a running load, e.g. {"qps": 20, "concurrency": 5}
+//	DELETE /loads/<name>   stop a running load
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hkdsun/simiload/load"
+	"github.com/hkdsun/simiload/load/config"
+	log "github.com/sirupsen/logrus"
+)
+
+type loadChange struct {
+	QPS         *float64 `json:"qps"`
+	Concurrency *int     `json:"concurrency"`
+}
+
+type Server struct {
+	Generator *load.Generator
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+
+	switch {
+	case path == "loads" && r.Method == http.MethodGet:
+		s.list(w)
+	case path == "loads" && r.Method == http.MethodPost:
+		s.start(w, r)
+	case strings.HasPrefix(path, "loads/") && r.Method == http.MethodPatch:
+		s.change(w, r, strings.TrimPrefix(path, "loads/"))
+	case strings.HasPrefix(path, "loads/") && r.Method == http.MethodDelete:
+		s.stop(w, strings.TrimPrefix(path, "loads/"))
+	case path == "loads" || strings.HasPrefix(path, "loads/"):
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, s.Generator.Status())
+}
+
+func (s *Server) start(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	l, err := config.ParseLoad("request", data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if l.Name == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("load must have a name"))
+		return
+	}
+
+	if err := s.Generator.StartLoad(*l); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	log.WithField("name", l.Name).Info("Load started through control API")
+	writeJSON(w, http.StatusCreated, map[string]string{"name": l.Name})
+}
+
+func (s *Server) change(w http.ResponseWriter, r *http.Request, name string) {
+	var change loadChange
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&change); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if change.QPS == nil && change.Concurrency == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("expected qps or concurrency"))
+		return
+	}
+
+	if change.QPS != nil {
+		if err := s.Generator.SetQPS(name, *change.QPS); err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+	}
+	if change.Concurrency != nil {
+		if err := s.Generator.SetConcurrency(name, *change.Concurrency); err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+	}
+
+	s.writeStatus(w, name)
+}
+
+func (s *Server) stop(w http.ResponseWriter, name string) {
+	if err := s.Generator.StopLoad(name); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	log.WithField("name", name).Info("Load stopped through control API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) writeStatus(w http.ResponseWriter, name string) {
+	statuses := s.Generator.Status()
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if statuses[i].Name == name {
+			writeJSON(w, http.StatusOK, statuses[i])
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, load.ErrNoSuchLoad)
+}
+
+func errorStatus(err error) int {
+	if err == load.ErrNoSuchLoad {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -1,37 +1,95 @@
 package load
 
 import (
+	"errors"
 	"fmt"
-	"math"
-	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"sync"
 	"time"
 
 	"github.com/containous/traefik/log"
-	"github.com/rakyll/hey/requester"
+)
+
+const (
+	// Workers wait for their previous response before sending again, so a
+	// slow server lowers the offered load
+	ArrivalClosed = "closed"
+	// Open-loop arrivals with exponentially distributed gaps
+	ArrivalPoisson = "poisson"
+	// Open-loop arrivals at fixed intervals
+	ArrivalUniform = "uniform"
 )
 
 type Load struct {
+	Name        string
 	StartAfter  time.Duration
 	Duration    time.Duration
 	Concurrency int
-	QPS         float64
+	QPS         float64 // per worker
 	Path        string
+	Method      string
+	Headers     map[string]string // values are templates, see RequestData
+	Body        []byte            // template, see RequestData
+	ContentType string
+	Target      string // overrides the generator's ServerURL
+	Traceparent bool   // starts a sampled trace with every request
+
+	// Overrides QPS with a rate that varies over the lifetime of the load.
+	// The rate is per worker, as QPS is.
+	Profile Profile
+
+	// Open-loop loads offer Concurrency times the per worker rate no matter
+	// how the server responds, up to MaxInFlight outstanding requests
+	Arrival     string
+	MaxInFlight int
+	Seed        int64
+
+	// Replaces Path with requests spread over many shops and clients
+	Population *Population
+}
+
+// Snapshot of a load as reported by the generator's control API
+type LoadStatus struct {
+	Name           string           `json:"name"`
+	Running        bool             `json:"running"`
+	Arrival        string           `json:"arrival"`
+	QPS            float64          `json:"qps"` // per worker
+	Concurrency    int              `json:"concurrency"`
+	ElapsedSeconds float64          `json:"elapsed_seconds"`
+	Sent           int64            `json:"sent"`
+	Statuses       map[string]int64 `json:"statuses"`
 }
 
+var ErrNoSuchLoad = errors.New("no running load with that name")
+
 type Generator struct {
 	ServerURL string
 	Loads     []*Load
 
-	workMut     *sync.Mutex
-	runningWork []*requester.Work
+	// Keeps running after the configured loads finish, until stopped, so
+	// that loads can be started through the control API
+	Persistent bool
+
+	initOnce    sync.Once
+	workMut     sync.Mutex
+	wg          *sync.WaitGroup
+	names       map[string]bool // loads that are pending or running
+	runningWork []*loadRun
+	results     []*LoadResult
+	stopChan    chan struct{}
+}
+
+func (g *Generator) init() {
+	g.initOnce.Do(func() {
+		g.wg = &sync.WaitGroup{}
+		g.names = make(map[string]bool)
+		g.stopChan = make(chan struct{})
+	})
 }
 
 func (g *Generator) Run() {
-	g.workMut = &sync.Mutex{}
+	g.init()
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
@@ -39,76 +97,219 @@ func (g *Generator) Run() {
 		g.Stop()
 	}()
 
-	wg := &sync.WaitGroup{}
-
-	for _, l := range g.Loads {
-		var load Load = *l
-		wg.Add(1)
+	if g.Persistent {
+		g.wg.Add(1)
 		go func() {
-			defer wg.Done()
-			g.ExecuteLoadAfter(load, load.StartAfter)
+			defer g.wg.Done()
+			<-g.stopChan
 		}()
 	}
 
-	wg.Wait()
+	for i, l := range g.Loads {
+		var load Load = *l
+
+		if load.Name == "" {
+			load.Name = load.Path
+		}
+		if load.Name == "" || g.nameTaken(load.Name) {
+			load.Name = fmt.Sprintf("load-%d", i)
+		}
+
+		if err := g.StartLoad(load); err != nil {
+			log.WithError(err).WithField("name", load.Name).Error("Unable to start load")
+		}
+	}
+
+	g.wg.Wait()
 }
 
 func (g *Generator) Stop() {
+	g.init()
+	g.workMut.Lock()
+	defer g.workMut.Unlock()
+
+	select {
+	case <-g.stopChan:
+	default:
+		close(g.stopChan)
+	}
+
 	for _, work := range g.runningWork {
-		work.Stop()
+		work.stop()
 	}
 }
 
-func (g *Generator) ExecuteLoadAfter(load Load, wait time.Duration) {
-	<-time.After(wait)
-	g.ExecuteLoad(load)
+// Starts a load after its StartAfter delay. Names must be unique among the
+// loads that are pending or running.
+func (g *Generator) StartLoad(load Load) error {
+	g.init()
+	g.workMut.Lock()
+	defer g.workMut.Unlock()
+
+	select {
+	case <-g.stopChan:
+		return fmt.Errorf("generator is stopped")
+	default:
+	}
+
+	if load.Name == "" {
+		return fmt.Errorf("load has no name")
+	}
+	if g.names[load.Name] {
+		return fmt.Errorf("load %q is already running", load.Name)
+	}
+	g.names[load.Name] = true
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer g.releaseName(load.Name)
+		g.ExecuteLoadAfter(load, load.StartAfter)
+	}()
+
+	return nil
 }
 
-func (g *Generator) ExecuteLoad(load Load) {
-	log.WithField("path", load.Path).WithField("qps", load.QPS).WithField("concurrency", load.Concurrency).Infof("Starting load")
+func (g *Generator) StopLoad(name string) error {
+	work, err := g.findRunning(name)
+	if err != nil {
+		return err
+	}
 
-	uri := fmt.Sprintf("%s/%s", g.ServerURL, load.Path)
+	work.stop()
+	return nil
+}
 
-	req, err := http.NewRequest("GET", uri, nil)
+// Replaces the load's profile with a constant rate per worker
+func (g *Generator) SetQPS(name string, qps float64) error {
+	if qps < 0 {
+		return fmt.Errorf("qps must not be negative")
+	}
+
+	work, err := g.findRunning(name)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	var body []byte
-	var proxyAddr *url.URL
+	log.WithField("name", name).WithField("qps", qps).Infof("Changing load rate")
+	work.setQPS(qps)
+	return nil
+}
 
-	work := &requester.Work{
-		Request:            req,
-		RequestBody:        body,
-		C:                  load.Concurrency, // num workers
-		QPS:                load.QPS,
-		N:                  math.MaxInt32,
-		Timeout:            25,
-		DisableCompression: false,
-		DisableKeepAlives:  false,
-		DisableRedirects:   false,
-		H2:                 false,
-		ProxyAddr:          proxyAddr,
-		Output:             "",
+func (g *Generator) SetConcurrency(name string, concurrency int) error {
+	if concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1")
 	}
-	work.Init()
 
-	g.registerWork(work)
+	work, err := g.findRunning(name)
+	if err != nil {
+		return err
+	}
 
-	if load.Duration > 0 {
-		go func() {
-			time.Sleep(load.Duration)
-			work.Stop()
-		}()
+	log.WithField("name", name).WithField("concurrency", concurrency).Infof("Changing load concurrency")
+	work.setConcurrency(concurrency)
+	return nil
+}
+
+// Every load that has started, in start order
+func (g *Generator) Status() []LoadStatus {
+	g.init()
+	g.workMut.Lock()
+	work := append([]*loadRun(nil), g.runningWork...)
+	g.workMut.Unlock()
+
+	statuses := make([]LoadStatus, len(work))
+	for i, w := range work {
+		statuses[i] = w.status()
 	}
+	return statuses
+}
 
-	work.Run()
-	log.WithField("path", load.Path).WithField("qps", load.QPS).WithField("concurrency", load.Concurrency).Infof("Finished load")
+func (g *Generator) findRunning(name string) (*loadRun, error) {
+	g.init()
+	g.workMut.Lock()
+	defer g.workMut.Unlock()
+
+	for i := len(g.runningWork) - 1; i >= 0; i-- {
+		work := g.runningWork[i]
+		if work.load.Name == name && work.running() {
+			return work, nil
+		}
+	}
+	return nil, ErrNoSuchLoad
 }
 
-func (g *Generator) registerWork(work *requester.Work) {
+func (g *Generator) nameTaken(name string) bool {
 	g.workMut.Lock()
 	defer g.workMut.Unlock()
 
+	return g.names[name]
+}
+
+func (g *Generator) releaseName(name string) {
+	g.workMut.Lock()
+	defer g.workMut.Unlock()
+
+	delete(g.names, name)
+}
+
+func (g *Generator) ExecuteLoadAfter(load Load, wait time.Duration) {
+	select {
+	case <-time.After(wait):
+		g.ExecuteLoad(load)
+	case <-g.stopChan:
+	}
+}
+
+func (g *Generator) ExecuteLoad(load Load) {
+	if load.Profile == nil {
+		load.Profile = ConstantProfile{load.QPS}
+	}
+	if load.Arrival == "" {
+		load.Arrival = ArrivalClosed
+	}
+	if load.MaxInFlight <= 0 {
+		load.MaxInFlight = 10000
+	}
+	if load.Seed == 0 {
+		load.Seed = time.Now().UnixNano()
+	}
+
+	log.WithField("name", load.Name).WithField("qps", load.QPS).WithField("concurrency", load.Concurrency).WithField("arrival", load.Arrival).WithField("seed", load.Seed).Infof("Starting load")
+
+	work, err := newLoadRun(g.ServerURL, load)
+	if err != nil {
+		log.WithError(err).WithField("name", load.Name).Error("Unable to start load")
+		return
+	}
+
+	if !g.registerWork(work) {
+		return
+	}
+
+	work.run()
+	log.WithField("name", load.Name).WithField("qps", work.qps()).WithField("concurrency", work.currentConcurrency()).Infof("Finished load")
+}
+
+func (g *Generator) registerWork(work *loadRun) bool {
+	g.workMut.Lock()
+	defer g.workMut.Unlock()
+
+	select {
+	case <-g.stopChan:
+		return false
+	default:
+	}
+
 	g.runningWork = append(g.runningWork, work)
+	g.results = append(g.results, work.result)
+	return true
+}
+
+// Results of the loads that have started, in start order
+func (g *Generator) Results() []*LoadResult {
+	g.workMut.Lock()
+	defer g.workMut.Unlock()
+
+	return append([]*LoadResult(nil), g.results...)
 }
@@ -0,0 +1,73 @@
+package load
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Takes over a share of a load's requests while it is active
+type HotShop struct {
+	ShopId     int
+	Share      float64
+	StartAfter time.Duration
+	Duration   time.Duration // zero means until the load ends
+}
+
+func (h HotShop) active(elapsed time.Duration) bool {
+	if elapsed < h.StartAfter {
+		return false
+	}
+	return h.Duration == 0 || elapsed < h.StartAfter+h.Duration
+}
+
+// Spreads a load over many shops and clients whose popularity follows a Zipf
+// distribution. Shops are numbered from 1 and clients are named c1, c2, ...
+type Population struct {
+	Shops      int
+	Clients    int
+	ShopSkew   float64 // Zipf exponent, must be greater than 1
+	ClientSkew float64
+	HotShops   []HotShop
+}
+
+// Draws request paths from a Population, safe for concurrent use
+type populationSampler struct {
+	population Population
+	mut        sync.Mutex
+	rng        *rand.Rand
+	shops      *rand.Zipf
+	clients    *rand.Zipf
+}
+
+func newPopulationSampler(population Population, seed int64) *populationSampler {
+	rng := rand.New(rand.NewSource(seed))
+
+	return &populationSampler{
+		population: population,
+		rng:        rng,
+		shops:      rand.NewZipf(rng, population.ShopSkew, 1, uint64(population.Shops-1)),
+		clients:    rand.NewZipf(rng, population.ClientSkew, 1, uint64(population.Clients-1)),
+	}
+}
+
+func (s *populationSampler) path(elapsed time.Duration) string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	client := s.clients.Uint64() + 1
+
+	roll := s.rng.Float64()
+	for _, hot := range s.population.HotShops {
+		if !hot.active(elapsed) {
+			continue
+		}
+		if roll < hot.Share {
+			return fmt.Sprintf("shop/%d/c%d", hot.ShopId, client)
+		}
+		roll -= hot.Share
+	}
+
+	return fmt.Sprintf("shop/%d/c%d", s.shops.Uint64()+1, client)
+}
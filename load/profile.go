@@ -0,0 +1,106 @@
+package load
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Rate at which a load issues requests, evaluated continuously over the time
+// elapsed since the load started
+type Profile interface {
+	QPS(elapsed time.Duration) float64
+}
+
+type ConstantProfile struct {
+	Rate float64
+}
+
+func (p ConstantProfile) QPS(elapsed time.Duration) float64 {
+	return p.Rate
+}
+
+// Linear ramp from From to To, holding To afterwards
+type RampProfile struct {
+	From float64
+	To   float64
+	Over time.Duration
+}
+
+func (p RampProfile) QPS(elapsed time.Duration) float64 {
+	if elapsed >= p.Over {
+		return p.To
+	}
+	return p.From + (p.To-p.From)*elapsed.Seconds()/p.Over.Seconds()
+}
+
+// Exponential growth from Base to Peak, holding Peak afterwards. Models the
+// onset of a flash sale better than a linear ramp.
+type SurgeProfile struct {
+	Base float64
+	Peak float64
+	Over time.Duration
+}
+
+func (p SurgeProfile) QPS(elapsed time.Duration) float64 {
+	if elapsed >= p.Over {
+		return p.Peak
+	}
+
+	base := math.Max(p.Base, 1e-3)
+	return base * math.Pow(p.Peak/base, elapsed.Seconds()/p.Over.Seconds())
+}
+
+// Periodic load, e.g. a compressed diurnal cycle
+type SineProfile struct {
+	Base      float64
+	Amplitude float64
+	Period    time.Duration
+}
+
+func (p SineProfile) QPS(elapsed time.Duration) float64 {
+	qps := p.Base + p.Amplitude*math.Sin(2*math.Pi*elapsed.Seconds()/p.Period.Seconds())
+	return math.Max(qps, 0)
+}
+
+type ProfilePoint struct {
+	At  time.Duration
+	QPS float64
+}
+
+// Holds the rate of the latest point reached, and zero before the first one
+type StepProfile struct {
+	Steps []ProfilePoint
+}
+
+func (p StepProfile) QPS(elapsed time.Duration) float64 {
+	i := sort.Search(len(p.Steps), func(i int) bool { return p.Steps[i].At > elapsed })
+	if i == 0 {
+		return 0
+	}
+	return p.Steps[i-1].QPS
+}
+
+// Interpolates linearly between points, holding the first and last rate
+// before and after them
+type PiecewiseProfile struct {
+	Points []ProfilePoint
+}
+
+func (p PiecewiseProfile) QPS(elapsed time.Duration) float64 {
+	if len(p.Points) == 0 {
+		return 0
+	}
+
+	i := sort.Search(len(p.Points), func(i int) bool { return p.Points[i].At > elapsed })
+	if i == 0 {
+		return p.Points[0].QPS
+	}
+	if i == len(p.Points) {
+		return p.Points[len(p.Points)-1].QPS
+	}
+
+	from, to := p.Points[i-1], p.Points[i]
+	progress := float64(elapsed-from.At) / float64(to.At-from.At)
+	return from.QPS + (to.QPS-from.QPS)*progress
+}
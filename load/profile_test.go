@@ -0,0 +1,43 @@
+package load
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestProfiles(t *testing.T) {
+	points := []ProfilePoint{{At: 10 * time.Second, QPS: 10}, {At: 20 * time.Second, QPS: 30}}
+
+	tests := []struct {
+		name    string
+		profile Profile
+		elapsed time.Duration
+		qps     float64
+	}{
+		{"constant", ConstantProfile{Rate: 5}, time.Hour, 5},
+		{"ramp start", RampProfile{From: 10, To: 20, Over: 10 * time.Second}, 0, 10},
+		{"ramp middle", RampProfile{From: 10, To: 20, Over: 10 * time.Second}, 5 * time.Second, 15},
+		{"ramp end", RampProfile{From: 10, To: 20, Over: 10 * time.Second}, time.Minute, 20},
+		{"surge start", SurgeProfile{Base: 1, Peak: 100, Over: 10 * time.Second}, 0, 1},
+		{"surge middle", SurgeProfile{Base: 1, Peak: 100, Over: 10 * time.Second}, 5 * time.Second, 10},
+		{"surge end", SurgeProfile{Base: 1, Peak: 100, Over: 10 * time.Second}, time.Minute, 100},
+		{"sine peak", SineProfile{Base: 10, Amplitude: 5, Period: 4 * time.Second}, 1 * time.Second, 15},
+		{"sine trough", SineProfile{Base: 10, Amplitude: 5, Period: 4 * time.Second}, 3 * time.Second, 5},
+		{"sine clamped", SineProfile{Base: 1, Amplitude: 5, Period: 4 * time.Second}, 3 * time.Second, 0},
+		{"step before", StepProfile{Steps: points}, 5 * time.Second, 0},
+		{"step at", StepProfile{Steps: points}, 10 * time.Second, 10},
+		{"step between", StepProfile{Steps: points}, 15 * time.Second, 10},
+		{"step after", StepProfile{Steps: points}, time.Minute, 30},
+		{"piecewise before", PiecewiseProfile{Points: points}, 5 * time.Second, 10},
+		{"piecewise between", PiecewiseProfile{Points: points}, 15 * time.Second, 20},
+		{"piecewise after", PiecewiseProfile{Points: points}, time.Minute, 30},
+		{"piecewise empty", PiecewiseProfile{}, time.Second, 0},
+	}
+
+	for _, test := range tests {
+		if qps := test.profile.QPS(test.elapsed); math.Abs(qps-test.qps) > 1e-9 {
+			t.Errorf("%s: %v qps after %v, want %v", test.name, qps, test.elapsed, test.qps)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package load
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containous/traefik/log"
+)
+
+// A request recorded from real traffic
+type Record struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	ShopId    int               `json:"shop_id"`
+	ClientId  string            `json:"client_id"`
+	Headers   map[string]string `json:"headers"`
+	LatencyMs float64           `json:"latency_ms"` // as originally observed
+
+	// Simulation access logs record the latency as total_time_ms
+	TotalTimeMs float64 `json:"total_time_ms"`
+}
+
+func (r Record) Latency() time.Duration {
+	latencyMs := r.LatencyMs
+	if latencyMs == 0 {
+		latencyMs = r.TotalTimeMs
+	}
+	return time.Duration(latencyMs * float64(time.Millisecond))
+}
+
+// Reads one JSON record per line, ordered by timestamp
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if record.Timestamp.IsZero() {
+			return nil, fmt.Errorf("line %d: missing timestamp", line)
+		}
+
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+
+	return records, nil
+}
+
+func ReadRecordsFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadRecords(f)
+}
+
+// Reissues recorded requests with their original spacing, divided by Speed.
+// Requests are open-loop: a slow simulator does not delay later requests,
+// they are dropped instead once MaxInFlight requests are outstanding.
+type Replayer struct {
+	ServerURL   string
+	Records     []Record
+	Speed       float64
+	MaxInFlight int
+	Timeout     time.Duration
+
+	client   *http.Client
+	stopChan chan struct{}
+	stopOnce sync.Once
+
+	inFlight        int64
+	sent            int64
+	dropped         int64
+	failed          int64
+	statusMut       sync.Mutex
+	statuses        map[int]int
+	replayedLatency time.Duration
+	originalLatency time.Duration
+}
+
+func (r *Replayer) Run() {
+	if len(r.Records) == 0 {
+		log.Warn("Nothing to replay")
+		return
+	}
+
+	if r.Speed <= 0 {
+		r.Speed = 1
+	}
+	if r.MaxInFlight <= 0 {
+		r.MaxInFlight = 10000
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = 25 * time.Second
+	}
+
+	r.client = &http.Client{Timeout: r.Timeout}
+	r.stopChan = make(chan struct{})
+	r.statuses = make(map[int]int)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		r.Stop()
+	}()
+
+	first := r.Records[0].Timestamp
+	last := r.Records[len(r.Records)-1].Timestamp
+	log.WithField("records", len(r.Records)).
+		WithField("recorded_duration", last.Sub(first)).
+		WithField("speed", r.Speed).
+		Infof("Starting replay")
+
+	wg := &sync.WaitGroup{}
+	start := time.Now()
+
+replay:
+	for _, record := range r.Records {
+		offset := time.Duration(float64(record.Timestamp.Sub(first)) / r.Speed)
+
+		select {
+		case <-time.After(time.Until(start.Add(offset))):
+		case <-r.stopChan:
+			break replay
+		}
+
+		if atomic.LoadInt64(&r.inFlight) >= int64(r.MaxInFlight) {
+			atomic.AddInt64(&r.dropped, 1)
+			continue
+		}
+
+		atomic.AddInt64(&r.inFlight, 1)
+		wg.Add(1)
+		go func(record Record) {
+			defer wg.Done()
+			defer atomic.AddInt64(&r.inFlight, -1)
+			r.issue(record)
+		}(record)
+	}
+
+	wg.Wait()
+	r.logSummary(time.Since(start))
+}
+
+func (r *Replayer) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+	})
+}
+
+func (r *Replayer) issue(record Record) {
+	path := record.Path
+	if path == "" {
+		path = fmt.Sprintf("shop/%d/%s", record.ShopId, record.ClientId)
+	}
+
+	method := record.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", r.ServerURL, strings.TrimPrefix(path, "/")), nil)
+	if err != nil {
+		log.WithError(err).Error("unable to build replayed request")
+		atomic.AddInt64(&r.failed, 1)
+		return
+	}
+	for name, value := range record.Headers {
+		req.Header.Set(name, value)
+	}
+
+	atomic.AddInt64(&r.sent, 1)
+	start := time.Now()
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		atomic.AddInt64(&r.failed, 1)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	r.statusMut.Lock()
+	defer r.statusMut.Unlock()
+
+	r.statuses[resp.StatusCode]++
+	r.replayedLatency += time.Since(start)
+	r.originalLatency += record.Latency()
+}
+
+func (r *Replayer) logSummary(elapsed time.Duration) {
+	r.statusMut.Lock()
+	defer r.statusMut.Unlock()
+
+	completed := 0
+	for _, count := range r.statuses {
+		completed += count
+	}
+
+	entry := log.WithField("elapsed", elapsed).
+		WithField("sent", atomic.LoadInt64(&r.sent)).
+		WithField("dropped", atomic.LoadInt64(&r.dropped)).
+		WithField("failed", atomic.LoadInt64(&r.failed)).
+		WithField("statuses", r.statuses)
+
+	if completed > 0 {
+		entry = entry.
+			WithField("avg_latency", r.replayedLatency/time.Duration(completed)).
+			WithField("avg_original_latency", r.originalLatency/time.Duration(completed))
+	}
+
+	entry.Infof("Finished replay")
+}
@@ -0,0 +1,104 @@
+package load
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadRecords(t *testing.T) {
+	records, err := ReadRecords(strings.NewReader(`
+{"timestamp": "2020-01-01T00:00:02Z", "shop_id": 2, "total_time_ms": 30}
+{"timestamp": "2020-01-01T00:00:01Z", "shop_id": 1, "latency_ms": 10, "total_time_ms": 20}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 || records[0].ShopId != 1 || records[1].ShopId != 2 {
+		t.Fatalf("got %+v, want both records ordered by timestamp", records)
+	}
+	if records[0].Latency() != 10*time.Millisecond || records[1].Latency() != 30*time.Millisecond {
+		t.Errorf("latencies %v and %v, want latency_ms over total_time_ms", records[0].Latency(), records[1].Latency())
+	}
+
+	for _, invalid := range []string{`{"shop_id": 1}`, `{"timestamp": 1}`} {
+		if _, err := ReadRecords(strings.NewReader(invalid)); err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+			t.Errorf("%s: got %v, want an error for line 1", invalid, err)
+		}
+	}
+}
+
+func TestReplayPacing(t *testing.T) {
+	var mut sync.Mutex
+	var arrivals []time.Time
+	var requests []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+		arrivals = append(arrivals, time.Now())
+		requests = append(requests, req.Method+" "+req.URL.Path+" "+req.Header.Get("X-Class"))
+	}))
+	defer server.Close()
+
+	first := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	replayer := &Replayer{
+		ServerURL: server.URL,
+		Speed:     2,
+		Records: []Record{
+			{Timestamp: first, ShopId: 1, ClientId: "a"},
+			{Timestamp: first.Add(100 * time.Millisecond), Method: "POST", Path: "/checkout"},
+			{Timestamp: first.Add(200 * time.Millisecond), ShopId: 2, ClientId: "b", Headers: map[string]string{"X-Class": "bot"}},
+		},
+	}
+	replayer.Run()
+
+	want := []string{"GET /shop/1/a ", "POST /checkout ", "GET /shop/2/b bot"}
+	if len(requests) != len(want) {
+		t.Fatalf("replayed %v, want %v", requests, want)
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Errorf("request %d was %q, want %q", i, requests[i], want[i])
+		}
+	}
+
+	// The recorded spacing of 100ms, halved
+	for i := 1; i < len(arrivals); i++ {
+		if gap := arrivals[i].Sub(arrivals[i-1]); gap < 40*time.Millisecond || gap > 80*time.Millisecond {
+			t.Errorf("request %d arrived %v after the previous one, want 50ms", i, gap)
+		}
+	}
+}
+
+func TestReplayDropsPastMaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	first := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	replayer := &Replayer{
+		ServerURL:   server.URL,
+		MaxInFlight: 1,
+		Records: []Record{
+			{Timestamp: first, ShopId: 1},
+			{Timestamp: first.Add(20 * time.Millisecond), ShopId: 2},
+		},
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+	replayer.Run()
+
+	if replayer.sent != 1 || replayer.dropped != 1 {
+		t.Errorf("sent %d and dropped %d, want one each", replayer.sent, replayer.dropped)
+	}
+}
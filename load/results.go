@@ -0,0 +1,225 @@
+package load
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Relative width of the latency histogram buckets
+const histogramGrowth = 1.05
+
+// Latency histogram with logarithmic buckets, so that percentiles are within
+// a few percent and histograms from several runs can be merged exactly
+type Histogram struct {
+	Buckets map[int]int64 `json:"buckets"`
+	Count   int64         `json:"count"`
+	MaxMs   float64       `json:"max_ms"`
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{Buckets: make(map[int]int64)}
+}
+
+func (h *Histogram) Add(latency time.Duration) {
+	ms := latency.Seconds() * 1000
+	bucket := 0
+	if ms > 0.001 {
+		bucket = int(math.Ceil(math.Log(ms/0.001) / math.Log(histogramGrowth)))
+	}
+
+	h.Buckets[bucket]++
+	h.Count++
+	h.MaxMs = math.Max(h.MaxMs, ms)
+}
+
+func (h *Histogram) Merge(other *Histogram) {
+	for bucket, count := range other.Buckets {
+		h.Buckets[bucket] += count
+	}
+	h.Count += other.Count
+	h.MaxMs = math.Max(h.MaxMs, other.MaxMs)
+}
+
+// Upper bound of the bucket containing the p-th percentile, in milliseconds
+func (h *Histogram) Percentile(p float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+
+	buckets := make([]int, 0, len(h.Buckets))
+	for bucket := range h.Buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+
+	rank := int64(math.Ceil(p / 100 * float64(h.Count)))
+	var seen int64
+	for _, bucket := range buckets {
+		seen += h.Buckets[bucket]
+		if seen >= rank {
+			return math.Min(0.001*math.Pow(histogramGrowth, float64(bucket)), h.MaxMs)
+		}
+	}
+
+	return h.MaxMs
+}
+
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	type histogram Histogram
+	return json.Marshal(struct {
+		*histogram
+		P50 float64 `json:"p50_ms"`
+		P90 float64 `json:"p90_ms"`
+		P99 float64 `json:"p99_ms"`
+	}{(*histogram)(h), h.Percentile(50), h.Percentile(90), h.Percentile(99)})
+}
+
+const (
+	StatusTimeout = "timeout"
+	StatusError   = "error"
+	StatusDropped = "dropped" // never sent because too many were in flight
+)
+
+// The client's view of a load: what it offered and how the server responded
+type LoadResult struct {
+	Name           string           `json:"name"`
+	ElapsedSeconds float64          `json:"elapsed_seconds"`
+	TargetRequests float64          `json:"target_requests"`
+	Sent           int64            `json:"sent"`
+	Statuses       map[string]int64 `json:"statuses"`
+	Successes      *Histogram       `json:"successes"`
+	Rejections     *Histogram       `json:"rejections"`
+
+	mut sync.Mutex
+}
+
+func NewLoadResult(name string) *LoadResult {
+	return &LoadResult{
+		Name:       name,
+		Statuses:   make(map[string]int64),
+		Successes:  NewHistogram(),
+		Rejections: NewHistogram(),
+	}
+}
+
+func (r *LoadResult) TargetQPS() float64 {
+	if r.ElapsedSeconds == 0 {
+		return 0
+	}
+	return r.TargetRequests / r.ElapsedSeconds
+}
+
+func (r *LoadResult) AchievedQPS() float64 {
+	if r.ElapsedSeconds == 0 {
+		return 0
+	}
+	return float64(r.Sent) / r.ElapsedSeconds
+}
+
+func (r *LoadResult) MarshalJSON() ([]byte, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	type loadResult LoadResult
+	return json.Marshal(struct {
+		*loadResult
+		TargetQPS   float64 `json:"target_qps"`
+		AchievedQPS float64 `json:"achieved_qps"`
+	}{(*loadResult)(r), r.TargetQPS(), r.AchievedQPS()})
+}
+
+// Adds another run of the same load, e.g. from another generator process
+func (r *LoadResult) Merge(other *LoadResult) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	other.mut.Lock()
+	defer other.mut.Unlock()
+
+	r.ElapsedSeconds = math.Max(r.ElapsedSeconds, other.ElapsedSeconds)
+	r.TargetRequests += other.TargetRequests
+	r.Sent += other.Sent
+	for status, count := range other.Statuses {
+		r.Statuses[status] += count
+	}
+	r.Successes.Merge(other.Successes)
+	r.Rejections.Merge(other.Rejections)
+}
+
+func (r *LoadResult) recordResponse(status int, latency time.Duration) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.Statuses[strconv.Itoa(status)]++
+	switch {
+	case status == 429:
+		r.Rejections.Add(latency)
+	case status >= 200 && status < 300:
+		r.Successes.Add(latency)
+	}
+}
+
+func (r *LoadResult) recordStatus(status string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.Statuses[status]++
+}
+
+func (r *LoadResult) recordSent() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.Sent++
+}
+
+func (r *LoadResult) recordTarget(requests float64) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.TargetRequests += requests
+}
+
+func (r *LoadResult) finish(elapsed time.Duration) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.ElapsedSeconds = elapsed.Seconds()
+}
+
+// Prints one row per load with its status breakdown and latency percentiles
+func WriteResults(w io.Writer, results []*LoadResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "load\ttarget qps\tachieved qps\t200\t429\t500\t%s\t%s\t%s\tother\tok p50\tok p90\tok p99\t429 p50\t429 p99\t\n",
+		StatusTimeout, StatusError, StatusDropped)
+
+	for _, r := range results {
+		r.mut.Lock()
+
+		other := int64(0)
+		for status, count := range r.Statuses {
+			switch status {
+			case "200", "429", "500", StatusTimeout, StatusError, StatusDropped:
+			default:
+				other += count
+			}
+		}
+
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t\n",
+			r.Name, r.TargetQPS(), r.AchievedQPS(),
+			r.Statuses["200"], r.Statuses["429"], r.Statuses["500"],
+			r.Statuses[StatusTimeout], r.Statuses[StatusError], r.Statuses[StatusDropped], other,
+			r.Successes.Percentile(50), r.Successes.Percentile(90), r.Successes.Percentile(99),
+			r.Rejections.Percentile(50), r.Rejections.Percentile(99))
+
+		r.mut.Unlock()
+	}
+
+	return tw.Flush()
+}
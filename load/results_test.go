@@ -0,0 +1,54 @@
+package load
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	h := NewHistogram()
+	for _, ms := range []float64{0, 1, 1, 10, 100} {
+		h.Add(time.Duration(ms * float64(time.Millisecond)))
+	}
+
+	if h.Count != 5 || h.MaxMs != 100 {
+		t.Fatalf("count %d and max %v, want 5 and 100", h.Count, h.MaxMs)
+	}
+	if h.Buckets[0] != 1 {
+		t.Errorf("bucket 0 holds %d requests, want the one that took no time", h.Buckets[0])
+	}
+
+	// A bucket's upper bound is within one growth step of the latencies in it
+	for _, p := range []struct {
+		percentile, ms float64
+	}{{40, 1}, {60, 1}, {80, 10}, {100, 100}} {
+		got := h.Percentile(p.percentile)
+		if got < p.ms || got > p.ms*histogramGrowth {
+			t.Errorf("p%v is %v, want within [%v, %v]", p.percentile, got, p.ms, p.ms*histogramGrowth)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b, both := NewHistogram(), NewHistogram(), NewHistogram()
+	for i := 1; i <= 100; i++ {
+		d := time.Duration(i) * time.Millisecond
+		both.Add(d)
+		if i%2 == 0 {
+			a.Add(d)
+		} else {
+			b.Add(d)
+		}
+	}
+
+	a.Merge(b)
+	if a.Count != both.Count || a.MaxMs != both.MaxMs {
+		t.Errorf("merged count %d and max %v, want %d and %v", a.Count, a.MaxMs, both.Count, both.MaxMs)
+	}
+	for _, p := range []float64{50, 90, 99} {
+		if got, want := a.Percentile(p), both.Percentile(p); math.Abs(got-want) > 1e-9 {
+			t.Errorf("merged p%v is %v, want %v", p, got, want)
+		}
+	}
+}
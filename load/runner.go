@@ -0,0 +1,426 @@
+package load
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containous/traefik/log"
+)
+
+// Longest a worker sleeps before re-evaluating the load's profile
+const pacerResolution = 50 * time.Millisecond
+
+// Spaces out a worker's requests at a rate that may change while it waits
+type pacer struct {
+	rate   func() float64
+	tokens float64
+	last   time.Time
+}
+
+func (p *pacer) wait(stop <-chan struct{}) bool {
+	for {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+
+		now := time.Now()
+		rate := p.rate()
+
+		if p.last.IsZero() {
+			// The first request goes out as soon as the rate allows it
+			p.tokens = 1
+		} else {
+			// Never accumulate more than a single request of slack
+			p.tokens = math.Min(p.tokens+rate*now.Sub(p.last).Seconds(), 1)
+		}
+		p.last = now
+
+		if p.tokens >= 1 && rate > 0 {
+			p.tokens--
+			return true
+		}
+
+		sleep := pacerResolution
+		if rate > 0 {
+			if d := time.Duration((1 - p.tokens) / rate * float64(time.Second)); d < sleep {
+				sleep = d
+			}
+		}
+
+		select {
+		case <-time.After(sleep):
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// Schedules open-loop arrivals. Each gap is drawn in units of 1/rate and
+// consumed at whatever the rate is while waiting, so a changing profile
+// shapes the arrival process without restarting it.
+type arrivalClock struct {
+	rate      func() float64
+	gap       func() float64
+	remaining float64
+	last      time.Time
+}
+
+func (a *arrivalClock) wait(stop <-chan struct{}) bool {
+	if a.last.IsZero() {
+		a.last = time.Now()
+		a.remaining = a.gap()
+	}
+
+	for {
+		now := time.Now()
+		rate := a.rate()
+
+		a.remaining -= rate * now.Sub(a.last).Seconds()
+		a.last = now
+
+		// Arrivals that are overdue go out immediately to catch up
+		if a.remaining <= 0 {
+			a.remaining += a.gap()
+			return true
+		}
+
+		sleep := pacerResolution
+		if rate > 0 {
+			if d := time.Duration(a.remaining / rate * float64(time.Second)); d < sleep {
+				sleep = d
+			}
+		}
+
+		select {
+		case <-time.After(sleep):
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// A running load. Closed-loop loads run Concurrency workers that each issue
+// requests back to back, paced at the profile's rate. Open-loop loads issue
+// requests at Concurrency times that rate regardless of how quickly they
+// complete.
+type loadRun struct {
+	load      Load
+	serverURL string
+	client    *http.Client
+	started   time.Time
+	sampler   *populationSampler
+	template  *requestTemplate
+	result    *LoadResult
+
+	// Each random stream has its own seed, drawn from Load.Seed, so that
+	// arrival gaps and population choices are not correlated
+	arrivalSeed int64
+
+	inFlight int64
+
+	// Adjustable while the load runs
+	mut         sync.Mutex
+	concurrency int
+	qpsOverride float64
+	overridden  bool
+	workers     []chan struct{}
+	workerWg    sync.WaitGroup
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newLoadRun(serverURL string, load Load) (*loadRun, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	idleConns := load.Concurrency
+	if load.Arrival != ArrivalClosed {
+		idleConns = load.MaxInFlight
+		if idleConns > 1000 {
+			idleConns = 1000
+		}
+	}
+
+	if load.Target != "" {
+		serverURL = load.Target
+	}
+
+	r := &loadRun{
+		load:      load,
+		serverURL: serverURL,
+		client: &http.Client{
+			Timeout: 25 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: idleConns,
+			},
+		},
+		result:      NewLoadResult(load.Name),
+		concurrency: load.Concurrency,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+
+	seeds := rand.New(rand.NewSource(load.Seed))
+	r.arrivalSeed = seeds.Int63()
+	if load.Population != nil {
+		r.sampler = newPopulationSampler(*load.Population, seeds.Int63())
+	}
+
+	template, err := newRequestTemplate(load)
+	if err != nil {
+		return nil, err
+	}
+	r.template = template
+
+	return r, nil
+}
+
+func (r *loadRun) path() string {
+	if r.sampler != nil {
+		return r.sampler.path(time.Since(r.started))
+	}
+	return r.load.Path
+}
+
+// Current rate per worker
+func (r *loadRun) qps() float64 {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.overridden {
+		return r.qpsOverride
+	}
+	return r.load.Profile.QPS(time.Since(r.started))
+}
+
+func (r *loadRun) currentConcurrency() int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.concurrency
+}
+
+// Replaces the load's profile with a constant rate per worker
+func (r *loadRun) setQPS(qps float64) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.qpsOverride = qps
+	r.overridden = true
+}
+
+// Starts or stops workers of closed-loop loads, and scales the arrival rate
+// of open-loop ones
+func (r *loadRun) setConcurrency(concurrency int) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.concurrency = concurrency
+
+	if r.load.Arrival != ArrivalClosed || r.ctx.Err() != nil {
+		return
+	}
+
+	for len(r.workers) < concurrency {
+		quit := make(chan struct{})
+		r.workers = append(r.workers, quit)
+		r.workerWg.Add(1)
+		go r.worker(quit)
+	}
+
+	for len(r.workers) > concurrency {
+		close(r.workers[len(r.workers)-1])
+		r.workers = r.workers[:len(r.workers)-1]
+	}
+}
+
+func (r *loadRun) run() {
+	defer close(r.done)
+
+	r.mut.Lock()
+	r.started = time.Now()
+	r.mut.Unlock()
+
+	if r.load.Duration > 0 {
+		timer := time.AfterFunc(r.load.Duration, r.stop)
+		defer timer.Stop()
+	}
+
+	go r.trackTarget()
+	defer func() {
+		r.result.finish(time.Since(r.started))
+	}()
+
+	if r.load.Arrival == ArrivalClosed {
+		r.runClosedLoop()
+	} else {
+		r.runOpenLoop()
+	}
+}
+
+func (r *loadRun) runClosedLoop() {
+	r.setConcurrency(r.load.Concurrency)
+
+	<-r.ctx.Done()
+
+	r.mut.Lock()
+	for _, quit := range r.workers {
+		close(quit)
+	}
+	r.workers = nil
+	r.mut.Unlock()
+
+	r.workerWg.Wait()
+}
+
+func (r *loadRun) worker(quit chan struct{}) {
+	defer r.workerWg.Done()
+
+	p := &pacer{rate: r.qps}
+	for p.wait(quit) {
+		r.makeRequest()
+	}
+}
+
+func (r *loadRun) runOpenLoop() {
+	rng := rand.New(rand.NewSource(r.arrivalSeed))
+
+	clock := &arrivalClock{
+		rate: func() float64 {
+			return r.qps() * float64(r.currentConcurrency())
+		},
+		gap: func() float64 {
+			if r.load.Arrival == ArrivalPoisson {
+				return rng.ExpFloat64()
+			}
+			return 1
+		},
+	}
+
+	wg := &sync.WaitGroup{}
+
+	for clock.wait(r.ctx.Done()) {
+		if atomic.LoadInt64(&r.inFlight) >= int64(r.load.MaxInFlight) {
+			r.result.recordStatus(StatusDropped)
+			continue
+		}
+
+		atomic.AddInt64(&r.inFlight, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer atomic.AddInt64(&r.inFlight, -1)
+			r.makeRequest()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (r *loadRun) stop() {
+	r.stopOnce.Do(r.cancel)
+}
+
+func (r *loadRun) running() bool {
+	select {
+	case <-r.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (r *loadRun) status() LoadStatus {
+	status := LoadStatus{
+		Name:        r.load.Name,
+		Running:     r.running(),
+		Arrival:     r.load.Arrival,
+		QPS:         r.qps(),
+		Concurrency: r.currentConcurrency(),
+	}
+
+	r.mut.Lock()
+	started := r.started
+	r.mut.Unlock()
+
+	r.result.mut.Lock()
+	defer r.result.mut.Unlock()
+
+	status.Sent = r.result.Sent
+	status.Statuses = make(map[string]int64, len(r.result.Statuses))
+	for s, count := range r.result.Statuses {
+		status.Statuses[s] = count
+	}
+
+	if status.Running && !started.IsZero() {
+		status.ElapsedSeconds = time.Since(started).Seconds()
+	} else {
+		status.ElapsedSeconds = r.result.ElapsedSeconds
+	}
+
+	return status
+}
+
+func (r *loadRun) makeRequest() {
+	path := r.path()
+	req, err := r.template.newRequest(fmt.Sprintf("%s/%s", r.serverURL, path), path)
+	if err != nil {
+		log.WithError(err).WithField("load", r.load.Name).Error("unable to build request")
+		r.result.recordStatus(StatusError)
+		return
+	}
+
+	start := time.Now()
+	resp, err := r.client.Do(req.WithContext(r.ctx))
+	if err != nil {
+		// Requests cut short by stopping the load are not counted
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		r.result.recordSent()
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			r.result.recordStatus(StatusTimeout)
+		} else {
+			r.result.recordStatus(StatusError)
+		}
+		return
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	r.result.recordSent()
+	r.result.recordResponse(resp.StatusCode, time.Since(start))
+}
+
+// Accumulates the number of requests the load should have offered so far
+func (r *loadRun) trackTarget() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	last := r.started
+	for {
+		select {
+		case now := <-ticker.C:
+			r.result.recordTarget(r.qps() * float64(r.currentConcurrency()) * now.Sub(last).Seconds())
+			last = now
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
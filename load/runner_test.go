@@ -0,0 +1,68 @@
+package load
+
+import (
+	"testing"
+	"time"
+)
+
+func constantRate(qps float64) func() float64 {
+	return func() float64 { return qps }
+}
+
+func unitGap() float64 {
+	return 1
+}
+
+func TestArrivalClockRate(t *testing.T) {
+	clock := &arrivalClock{rate: constantRate(200), gap: unitGap}
+	stop := make(chan struct{})
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if !clock.wait(stop) {
+			t.Fatal("the clock stopped")
+		}
+	}
+
+	// 20 gaps of 5ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("20 arrivals at 200 qps took %v, want about 100ms", elapsed)
+	}
+}
+
+func TestArrivalClockCatchesUp(t *testing.T) {
+	// A second overdue at 10 qps
+	clock := &arrivalClock{
+		rate:      constantRate(10),
+		gap:       unitGap,
+		remaining: 1,
+		last:      time.Now().Add(-1 * time.Second),
+	}
+	stop := make(chan struct{})
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		clock.wait(stop)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("overdue arrivals took %v, want them immediately", elapsed)
+	}
+}
+
+func TestArrivalClockStops(t *testing.T) {
+	clock := &arrivalClock{rate: constantRate(0), gap: unitGap}
+	stop := make(chan struct{})
+	close(stop)
+
+	done := make(chan bool)
+	go func() { done <- clock.wait(stop) }()
+
+	select {
+	case arrived := <-done:
+		if arrived {
+			t.Error("an arrival at a rate of zero")
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("the clock did not stop")
+	}
+}
@@ -0,0 +1,112 @@
+package load
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/hkdsun/simiload/tracing"
+)
+
+// Data available to header and body templates, e.g. {"shop": {{.ShopId}}}
+type RequestData struct {
+	ShopId   string
+	ClientId string
+	Seq      int64 // number of the request within its load
+}
+
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// Renders the method, headers and body of a load's requests
+type requestTemplate struct {
+	method      string
+	contentType string
+	traceparent bool
+	headers     map[string]*template.Template
+	body        *template.Template
+	seq         int64
+}
+
+func newRequestTemplate(load Load) (*requestTemplate, error) {
+	t := &requestTemplate{
+		method:      load.Method,
+		contentType: load.ContentType,
+		traceparent: load.Traceparent,
+		headers:     make(map[string]*template.Template),
+	}
+
+	if t.method == "" {
+		t.method = "GET"
+	}
+
+	for name, value := range load.Headers {
+		header, err := ParseTemplate(name, value)
+		if err != nil {
+			return nil, err
+		}
+		t.headers[name] = header
+	}
+
+	if len(load.Body) > 0 {
+		body, err := ParseTemplate("body", string(load.Body))
+		if err != nil {
+			return nil, err
+		}
+		t.body = body
+	}
+
+	return t, nil
+}
+
+func (t *requestTemplate) newRequest(url, path string) (*http.Request, error) {
+	data := RequestData{Seq: atomic.AddInt64(&t.seq, 1)}
+
+	// Paths look like shop/<shop id>/<client id>[/<action>]
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segments) > 1 && segments[0] == "shop" {
+		data.ShopId = segments[1]
+	}
+	if len(segments) > 2 && segments[0] == "shop" {
+		data.ClientId = segments[2]
+	}
+
+	var body bytes.Buffer
+	if t.body != nil {
+		if err := t.body.Execute(&body, data); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(t.method, url, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, header := range t.headers {
+		var value bytes.Buffer
+		if err := header.Execute(&value, data); err != nil {
+			return nil, err
+		}
+
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value.String()
+			continue
+		}
+		req.Header.Set(name, value.String())
+	}
+
+	if t.contentType != "" {
+		req.Header.Set("Content-Type", t.contentType)
+	}
+
+	// A traceparent given as a header template takes precedence
+	if t.traceparent && req.Header.Get(tracing.TraceparentHeader) == "" {
+		req.Header.Set(tracing.TraceparentHeader, tracing.NewRootContext(true).Traceparent())
+	}
+
+	return req, nil
+}
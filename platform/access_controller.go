@@ -3,6 +3,16 @@ package platform
 import (
 	"math/rand"
 	"net/http"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// Whether a controller's decisions are enforced. The metrics a controller
+// emits are labelled with its role so that a dry run's do not mix with the
+// enforced controller's.
+const (
+	RoleActive = "active"
+	RoleShadow = "shadow"
 )
 
 type LoadAnalyzer interface {
@@ -15,14 +25,47 @@ type AccessController interface {
 	LogAccess(req *HttpRequest)
 }
 
-type DummyController struct{}
+// Implemented by controllers whose internal state is worth watching, such as
+// their measured load or active throttlers. Keys are gauge names.
+type StateReporter interface {
+	State() map[string]float64
+}
+
+// Implemented by controllers that throttle traffic, so that the throttlers
+// active at any time can be watched
+type ThrottleReporter interface {
+	Throttlers() []ThrottlerState
+}
+
+// Implemented by controllers that emit metrics of their own, and by the
+// controllers wrapping them
+type RoleSetter interface {
+	SetRole(role string)
+}
+
+func SetRole(controller AccessController, role string) {
+	if setter, ok := controller.(RoleSetter); ok {
+		setter.SetRole(role)
+	}
+}
+
+func roleLabel(role string) metrics.Label {
+	if role == "" {
+		role = RoleActive
+	}
+	return metrics.Label{Name: "role", Value: role}
+}
+
+type DummyController struct {
+	Rand *rand.Rand
+}
 
 func (d *DummyController) AllowAccess(req *HttpRequest) bool {
 	return true
 }
 
 func (d *DummyController) LogAccess(req *HttpRequest) {
-	if rand.Float64() < 1.0 {
+	if randFloat64(d.Rand) < 1.0 {
 		// fmt.Printf("req = %+v\n", req.RequestStats)
 	}
 }
@@ -35,8 +78,56 @@ func (d *ActiveController) AllowAccess(req *HttpRequest) bool {
 	return d.Analyzer.AllowAccess(req)
 }
 
+// Only requests a worker served are analyzed. Rejected, overflowed and
+// unserved requests never queued for a worker, and their times would make
+// the platform look less loaded than it is.
 func (d *ActiveController) LogAccess(req *HttpRequest) {
-	if d.Analyzer != nil && req.HttpStatus != http.StatusTooManyRequests {
+	if d.Analyzer != nil && req.HttpStatus == http.StatusOK && req.QueueOverflow == "" {
 		d.Analyzer.AnalyzeRequest(req)
 	}
 }
+
+func (d *ActiveController) SetRole(role string) {
+	if setter, ok := d.Analyzer.(RoleSetter); ok {
+		setter.SetRole(role)
+	}
+}
+
+func (d *ActiveController) ShareState(edge string, bus StateBus) {
+	if sharer, ok := d.Analyzer.(StateSharer); ok {
+		sharer.ShareState(edge, bus)
+	}
+}
+
+func (d *ActiveController) State() map[string]float64 {
+	if reporter, ok := d.Analyzer.(StateReporter); ok {
+		return reporter.State()
+	}
+	return nil
+}
+
+func (d *ActiveController) Throttlers() []ThrottlerState {
+	return throttlersOf(d.Analyzer)
+}
+
+// Copies a controller's state into another's, with keys prefixed by name
+func addState(into map[string]float64, name string, controller AccessController) {
+	reporter, ok := controller.(StateReporter)
+	if !ok {
+		return
+	}
+
+	for key, value := range reporter.State() {
+		if name != "" {
+			key = name + "." + key
+		}
+		into[key] = value
+	}
+}
+
+func throttlersOf(controller interface{}) []ThrottlerState {
+	if reporter, ok := controller.(ThrottleReporter); ok {
+		return reporter.Throttlers()
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package platform
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type AccessLogEntry struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Edge             string    `json:"edge,omitempty"`
+	Method           string    `json:"method"`
+	Path             string    `json:"path"`
+	ShopId           int       `json:"shop_id"`
+	ClientId         string    `json:"client_id"`
+	Class            string    `json:"class"`
+	Decision         string    `json:"decision"`
+	Status           int       `json:"status"`
+	RejectedBy       string    `json:"rejected_by,omitempty"`
+	Reason           string    `json:"reason,omitempty"`
+	QueueingTimeMs   float64   `json:"queueing_time_ms"`
+	ProcessingTimeMs float64   `json:"processing_time_ms"`
+	TotalTimeMs      float64   `json:"total_time_ms"`
+	QueueLength      int       `json:"queue_length"`
+	NumWorking       uint32    `json:"num_working"`
+	QueueOverflow    string    `json:"queue_overflow,omitempty"`
+}
+
+func NewAccessLogEntry(edge string, req *HttpRequest) AccessLogEntry {
+	entry := AccessLogEntry{
+		Timestamp:        req.ReceivedAt,
+		Edge:             edge,
+		ShopId:           req.ShopId,
+		ClientId:         req.ClientId,
+		Class:            req.Class,
+		Decision:         req.Decision,
+		Status:           req.HttpStatus,
+		RejectedBy:       req.RejectedBy,
+		Reason:           req.Reason,
+		QueueingTimeMs:   milliseconds(req.QueueingTime),
+		ProcessingTimeMs: milliseconds(req.ProcessingTime),
+		TotalTimeMs:      milliseconds(req.TotalTime),
+		QueueLength:      req.QueueLength,
+		NumWorking:       req.NumWorking,
+		QueueOverflow:    req.QueueOverflow,
+	}
+
+	if req.httpReq != nil {
+		entry.Method = req.httpReq.Method
+		entry.Path = req.httpReq.URL.Path
+	}
+
+	return entry
+}
+
+func milliseconds(d time.Duration) float64 {
+	return d.Seconds() * 1000
+}
+
+// Writes one JSON line per request. The file is rotated once it grows past
+// MaxBytes, keeping MaxBackups older files as <path>.1, <path>.2, ...
+type AccessLog struct {
+	Path       string
+	MaxBytes   int64
+	MaxBackups int
+
+	mut     sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	flusher *time.Ticker
+	done    chan struct{}
+}
+
+func NewAccessLog(path string, maxBytes int64, maxBackups int) (*AccessLog, error) {
+	l := &AccessLog{
+		Path:       path,
+		MaxBytes:   maxBytes,
+		MaxBackups: maxBackups,
+		flusher:    time.NewTicker(1 * time.Second),
+		done:       make(chan struct{}),
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-l.flusher.C:
+				l.Flush()
+			case <-l.done:
+				return
+			}
+		}
+	}()
+
+	return l, nil
+}
+
+func (l *AccessLog) Write(entry AccessLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.MaxBytes > 0 && l.size+int64(len(line)) > l.MaxBytes && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.writer.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *AccessLog) Flush() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.writer.Flush()
+}
+
+func (l *AccessLog) Close() error {
+	l.flusher.Stop()
+	close(l.done)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	return l.file.Close()
+}
+
+func (l *AccessLog) open() error {
+	file, err := os.OpenFile(l.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size = info.Size()
+	return nil
+}
+
+// Must be called with mut held
+func (l *AccessLog) rotate() error {
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	if l.MaxBackups > 0 {
+		for i := l.MaxBackups - 1; i > 0; i-- {
+			os.Rename(backupPath(l.Path, i), backupPath(l.Path, i+1))
+		}
+		if err := os.Rename(l.Path, backupPath(l.Path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.Path); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,73 @@
+package platform
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The shops of the entries logged in a file
+func loggedShops(t *testing.T, path string) []int {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var shops []int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AccessLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		shops = append(shops, entry.ShopId)
+	}
+	return shops
+}
+
+func TestAccessLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Room for two entries per file
+	line, err := json.Marshal(AccessLogEntry{ShopId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "access.log")
+	accessLog, err := NewAccessLog(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for shopId := 1; shopId <= 7; shopId++ {
+		if err := accessLog.Write(AccessLogEntry{ShopId: shopId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := accessLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]int{
+		path:                {7},
+		backupPath(path, 1): {5, 6},
+		backupPath(path, 2): {3, 4},
+	}
+	for file, shops := range want {
+		if got := loggedShops(t, file); !reflect.DeepEqual(got, shops) {
+			t.Errorf("%s holds shops %v, want %v", filepath.Base(file), got, shops)
+		}
+	}
+	if _, err := os.Stat(backupPath(path, 3)); !os.IsNotExist(err) {
+		t.Error("kept more than two backups")
+	}
+}
@@ -0,0 +1,125 @@
+package platform
+
+import (
+	"fmt"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+const (
+	// Stop at the first stage that denies the request
+	ChainFirstDeny = "first_deny"
+	// Every stage sees the request, which is denied if any of them denies it
+	ChainAllMustAllow = "all_must_allow"
+	// Only the first stage is enforced, the others are evaluated and recorded
+	ChainShadow = "shadow"
+)
+
+type ChainStage struct {
+	Name       string
+	Controller AccessController
+}
+
+// Evaluates several access controllers in order, e.g. a static rate limit in
+// front of load based shedding. The stage that rejects a request is recorded
+// in its AccessDecision.
+type ChainController struct {
+	Stages []ChainStage
+	Mode   string
+
+	role string
+}
+
+func (c *ChainController) AllowAccess(req *HttpRequest) bool {
+	switch c.Mode {
+	case ChainFirstDeny:
+		for _, stage := range c.Stages {
+			if allowed, decision := c.evaluate(stage, req); !allowed {
+				c.reject(stage, req, decision)
+				return false
+			}
+		}
+		return true
+	case ChainAllMustAllow:
+		allowed := true
+		for _, stage := range c.Stages {
+			if stageAllowed, decision := c.evaluate(stage, req); !stageAllowed && allowed {
+				c.reject(stage, req, decision)
+				allowed = false
+			}
+		}
+		return allowed
+	case ChainShadow:
+		allowed := true
+		for i, stage := range c.Stages {
+			stageAllowed, decision := c.evaluate(stage, req)
+			if stageAllowed {
+				continue
+			}
+
+			if i == 0 {
+				c.reject(stage, req, decision)
+				allowed = false
+			} else {
+				metrics.IncrCounterWithLabels(MetricChainShadowRejected.Key(), 1, []metrics.Label{{Name: "stage", Value: stage.Name}, roleLabel(c.role)})
+			}
+		}
+		return allowed
+	default:
+		panic(fmt.Sprintf("chain mode %s not recognized", c.Mode))
+	}
+}
+
+func (c *ChainController) LogAccess(req *HttpRequest) {
+	for _, stage := range c.Stages {
+		stage.Controller.LogAccess(req)
+	}
+}
+
+func (c *ChainController) SetRole(role string) {
+	c.role = role
+	for _, stage := range c.Stages {
+		SetRole(stage.Controller, role)
+	}
+}
+
+func (c *ChainController) ShareState(edge string, bus StateBus) {
+	for _, stage := range c.Stages {
+		if sharer, ok := stage.Controller.(StateSharer); ok {
+			sharer.ShareState(edge, bus)
+		}
+	}
+}
+
+// The state of every stage, prefixed with the stage's name
+func (c *ChainController) State() map[string]float64 {
+	state := make(map[string]float64)
+	for _, stage := range c.Stages {
+		addState(state, stage.Name, stage.Controller)
+	}
+	return state
+}
+
+func (c *ChainController) Throttlers() []ThrottlerState {
+	var throttlers []ThrottlerState
+	for _, stage := range c.Stages {
+		throttlers = append(throttlers, throttlersOf(stage.Controller)...)
+	}
+	return throttlers
+}
+
+// Returns the decision the stage recorded without applying it to the request,
+// since only the chain knows whether the stage is enforced
+func (c *ChainController) evaluate(stage ChainStage, req *HttpRequest) (bool, AccessDecision) {
+	decision := req.AccessDecision
+	allowed := stage.Controller.AllowAccess(req)
+	stageDecision := req.AccessDecision
+	req.AccessDecision = decision
+	return allowed, stageDecision
+}
+
+func (c *ChainController) reject(stage ChainStage, req *HttpRequest, decision AccessDecision) {
+	req.AccessDecision = decision
+	req.RejectedBy = stage.Name
+	metrics.IncrCounterWithLabels(MetricChainRejected.Key(), 1, []metrics.Label{{Name: "stage", Value: stage.Name}, roleLabel(c.role)})
+}
@@ -0,0 +1,68 @@
+package platform
+
+import (
+	"testing"
+)
+
+// Rejects the shops' requests with a reason and counts the requests it sees
+type countingDenier struct {
+	shops  map[int]bool
+	reason string
+	seen   *int
+}
+
+func (d countingDenier) AllowAccess(req *HttpRequest) bool {
+	*d.seen++
+	if d.shops[req.ShopId] {
+		req.Reason = d.reason
+		return false
+	}
+	return true
+}
+
+func (d countingDenier) LogAccess(req *HttpRequest) {}
+
+func TestChainModes(t *testing.T) {
+	// Shop 1 is denied by the first stage, shop 2 by the second and shop 12
+	// by both
+	tests := []struct {
+		mode       string
+		shopId     int
+		allowed    bool
+		rejectedBy string
+		reason     string
+		seen       [2]int
+	}{
+		{ChainFirstDeny, 1, false, "first", "one", [2]int{1, 0}},
+		{ChainFirstDeny, 2, false, "second", "two", [2]int{1, 1}},
+		{ChainFirstDeny, 3, true, "", "", [2]int{1, 1}},
+		{ChainAllMustAllow, 1, false, "first", "one", [2]int{1, 1}},
+		{ChainAllMustAllow, 2, false, "second", "two", [2]int{1, 1}},
+		{ChainAllMustAllow, 12, false, "first", "one", [2]int{1, 1}},
+		{ChainShadow, 1, false, "first", "one", [2]int{1, 1}},
+		{ChainShadow, 2, true, "", "", [2]int{1, 1}},
+		{ChainShadow, 12, false, "first", "one", [2]int{1, 1}},
+	}
+
+	for _, test := range tests {
+		var seen [2]int
+		c := &ChainController{
+			Mode: test.mode,
+			Stages: []ChainStage{
+				{Name: "first", Controller: countingDenier{map[int]bool{1: true, 12: true}, "one", &seen[0]}},
+				{Name: "second", Controller: countingDenier{map[int]bool{2: true, 12: true}, "two", &seen[1]}},
+			},
+		}
+
+		req := shopRequest(test.shopId)
+		allowed := c.AllowAccess(req)
+
+		if allowed != test.allowed || req.RejectedBy != test.rejectedBy || req.Reason != test.reason {
+			t.Errorf("%s, shop %d: allowed %t by %q for %q, want %t by %q for %q", test.mode, test.shopId,
+				allowed, req.RejectedBy, req.Reason, test.allowed, test.rejectedBy, test.reason)
+		}
+		if seen != test.seen {
+			t.Errorf("%s, shop %d: stages saw %v requests, want %v", test.mode, test.shopId, seen, test.seen)
+		}
+	}
+}
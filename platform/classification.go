@@ -0,0 +1,76 @@
+package platform
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	ClassCheckout = "checkout"
+	ClassWrite    = "write"
+	ClassRead     = "read"
+)
+
+// Lets clients declare the class of a request explicitly
+const ClassHeader = "X-Request-Class"
+
+// Classifies a request by how valuable it is to the platform. Checkouts are
+// recognised by an explicit header or a /checkout action in the path, other
+// requests by their method.
+func Classify(r *http.Request, action string) string {
+	switch class := strings.ToLower(r.Header.Get(ClassHeader)); class {
+	case ClassCheckout, ClassWrite, ClassRead:
+		return class
+	}
+
+	if action == "checkout" {
+		return ClassCheckout
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return ClassWrite
+	default:
+		return ClassRead
+	}
+}
+
+// Splits paths of the form /shop/<shop id>/<client id>[/<action>]
+func parseShopPath(path string) (shopId int, clientId, action string, err error) {
+	split := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(split) < 2 {
+		return 0, "", "", fmt.Errorf("no shop id in %s", path)
+	}
+
+	shopId, err = strconv.Atoi(split[1])
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	if len(split) > 2 {
+		clientId = split[2]
+	}
+	if len(split) > 3 {
+		action = split[3]
+	}
+
+	return shopId, clientId, action, nil
+}
+
+// Number of priorities returned by ClassPriority
+const numClassPriorities = 3
+
+// Order in which the priority scheduler serves classes, lowest first. Unknown
+// classes are served last.
+func ClassPriority(class string) int {
+	switch class {
+	case ClassCheckout:
+		return 0
+	case ClassWrite:
+		return 1
+	default:
+		return 2
+	}
+}
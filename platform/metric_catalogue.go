@@ -0,0 +1,272 @@
+package platform
+
+import "strings"
+
+// Service name the simulator's metrics are exported under
+const MetricService = "sim"
+
+// How a metric reaches Prometheus
+const (
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram" // see telemetry.PrometheusSink
+)
+
+// Groups of related metrics, shown as rows of the dashboard. Strategy
+// specific metrics are grouped by the strategy emitting them.
+const (
+	RowRequests    = "Requests"
+	RowWorkers     = "Workers"
+	RowControllers = "Controllers"
+	RowP1          = "p1"
+	RowProShed     = "pro_queueing / pro_num_workers"
+	RowRateLimit   = "rate_limit"
+	RowChain       = "chain"
+	RowShadow      = "shadow"
+	RowSharedState = "Shared state"
+)
+
+// A metric family emitted by the simulator. Names are as passed to
+// go-metrics; Prometheus sees them prefixed by the service name, with dots
+// replaced by underscores.
+type Metric struct {
+	Name   string
+	Kind   string
+	Help   string
+	Unit   string // Grafana unit of the values, short when empty
+	Labels []string
+	Row    string
+	// Controller state is also emitted with the name of a chain stage or
+	// "shadow" before its key, e.g. controller.p1.measured_load
+	Prefixed bool
+}
+
+func (m Metric) Key() []string {
+	return []string{m.Name}
+}
+
+func (m Metric) PrometheusName(service string) string {
+	return service + "_" + strings.Replace(m.Name, ".", "_", -1)
+}
+
+var (
+	MetricRequestCount = Metric{
+		Name:   "request.count",
+		Kind:   MetricCounter,
+		Help:   "Requests answered, by status and access decision",
+		Unit:   "reqps",
+		Labels: []string{"edge", "status", "decision", "class"},
+		Row:    RowRequests,
+	}
+	MetricRequestPassed = Metric{
+		Name:   "request.edge.passed",
+		Kind:   MetricCounter,
+		Help:   "Requests admitted by the edge",
+		Unit:   "reqps",
+		Labels: []string{"shop_id", "client_id", "class"},
+		Row:    RowRequests,
+	}
+	MetricRequestDropped = Metric{
+		Name:   "request.edge.dropped",
+		Kind:   MetricCounter,
+		Help:   "Requests rejected by the edge",
+		Unit:   "reqps",
+		Labels: []string{"shop_id", "client_id", "class"},
+		Row:    RowRequests,
+	}
+	MetricRequestTotalTime = Metric{
+		Name:   "request.total_time",
+		Kind:   MetricHistogram,
+		Help:   "Time from receiving a request to answering it",
+		Unit:   "ms",
+		Labels: []string{"shop_id", "decision"},
+		Row:    RowRequests,
+	}
+	MetricRequestQueueingTime = Metric{
+		Name:   "request.queueing_time",
+		Kind:   MetricHistogram,
+		Help:   "Time admitted requests waited for a worker",
+		Unit:   "ms",
+		Labels: []string{"shop_id", "decision"},
+		Row:    RowRequests,
+	}
+	MetricRequestProcessingTime = Metric{
+		Name:   "request.processing_time",
+		Kind:   MetricHistogram,
+		Help:   "Time a worker spent on admitted requests",
+		Unit:   "ms",
+		Labels: []string{"shop_id", "decision"},
+		Row:    RowRequests,
+	}
+
+	MetricWorkerPass = Metric{
+		Name: "worker.pass",
+		Kind: MetricCounter,
+		Help: "Requests taken off the work queue",
+		Unit: "reqps",
+		Row:  RowWorkers,
+	}
+	MetricWorkersOnline = Metric{
+		Name: "workers.online",
+		Kind: MetricGauge,
+		Help: "Workers in the worker group",
+		Row:  RowWorkers,
+	}
+	MetricWorkersUtilized = Metric{
+		Name: "workers.utilized",
+		Kind: MetricGauge,
+		Help: "Workers serving a request",
+		Row:  RowWorkers,
+	}
+	MetricWorkersQueueLength = Metric{
+		Name: "workers.queue_length",
+		Kind: MetricGauge,
+		Help: "Requests waiting for a worker",
+		Row:  RowWorkers,
+	}
+	MetricQueueOverflow = Metric{
+		Name:   "workers.queue_overflow",
+		Kind:   MetricCounter,
+		Help:   "Requests answered with a 503 because the work queue was full, by how they left the queue",
+		Unit:   "reqps",
+		Labels: []string{"outcome", "shop_id"},
+		Row:    RowWorkers,
+	}
+	MetricWorkersBusy = Metric{
+		Name: "workers.busy",
+		Kind: MetricHistogram,
+		Help: "Busy workers, sampled every 100ms",
+		Row:  RowWorkers,
+	}
+
+	MetricMeasuredLoad = Metric{
+		Name:   "measured_load",
+		Kind:   MetricGauge,
+		Help:   "Load last measured by the p1 or pro_* controller of any edge, by whether the controller is enforced",
+		Labels: []string{"role"},
+		Row:    RowControllers,
+	}
+	MetricControllerMeasuredLoad = controllerState("measured_load", "Load measured by the controller", RowControllers)
+
+	MetricControllerThreshold      = controllerState("threshold", "Average queueing time in seconds at which the platform is unhealthy", RowP1)
+	MetricControllerUnhealthy      = controllerState("unhealthy", "1 while the platform is unhealthy", RowP1)
+	MetricControllerThrottlers     = controllerState("throttlers", "Active throttlers", RowP1)
+	MetricControllerGlobalThrottle = controllerState("global_throttle", "1 while every shop is throttled", RowP1)
+
+	MetricControllerLocalLoad = controllerState("local_load", "Load measured by this edge alone", RowProShed)
+	MetricControllerSoftLimit = controllerState("soft_limit", "Load from which unprotected requests are shed", RowProShed)
+	MetricControllerHardLimit = controllerState("hard_limit", "Load from which every request is shed", RowProShed)
+
+	MetricRateLimitRejected = Metric{
+		Name:   "ratelimit.rejected",
+		Kind:   MetricCounter,
+		Help:   "Requests rejected by a shop's token bucket",
+		Unit:   "reqps",
+		Labels: []string{"shop_id", "role"},
+		Row:    RowRateLimit,
+	}
+	MetricRateLimitTokensConsumed = Metric{
+		Name:   "ratelimit.tokens_consumed",
+		Kind:   MetricCounter,
+		Help:   "Tokens taken from a shop's token bucket",
+		Unit:   "reqps",
+		Labels: []string{"shop_id", "role"},
+		Row:    RowRateLimit,
+	}
+	MetricRateLimitLimiters = Metric{
+		Name:   "ratelimit.limiters",
+		Kind:   MetricGauge,
+		Help:   "Token buckets in memory",
+		Labels: []string{"role"},
+		Row:    RowRateLimit,
+	}
+	MetricControllerLimiters = controllerState("limiters", "Token buckets in memory on this edge", RowRateLimit)
+
+	MetricChainRejected = Metric{
+		Name:   "access.chain.rejected",
+		Kind:   MetricCounter,
+		Help:   "Requests rejected, by the chain stage that rejected them",
+		Unit:   "reqps",
+		Labels: []string{"stage", "role"},
+		Row:    RowChain,
+	}
+	MetricChainShadowRejected = Metric{
+		Name:   "access.chain.shadow_rejected",
+		Kind:   MetricCounter,
+		Help:   "Requests a stage would have rejected in shadow mode",
+		Unit:   "reqps",
+		Labels: []string{"stage", "role"},
+		Row:    RowChain,
+	}
+
+	MetricShadowDecisions = Metric{
+		Name:   "access.shadow.decisions",
+		Kind:   MetricCounter,
+		Help:   "Decisions of the shadow strategy, by whether the active strategy agreed",
+		Unit:   "reqps",
+		Labels: []string{"shadow", "outcome", "shadow_allowed"},
+		Row:    RowShadow,
+	}
+
+	MetricStateDropped = Metric{
+		Name:   "state.dropped",
+		Kind:   MetricCounter,
+		Help:   "Shared state events dropped because an edge could not keep up",
+		Labels: []string{"edge"},
+		Row:    RowSharedState,
+	}
+)
+
+// Every metric the simulator emits, in dashboard order
+var MetricCatalogue = []Metric{
+	MetricRequestCount,
+	MetricRequestPassed,
+	MetricRequestDropped,
+	MetricRequestTotalTime,
+	MetricRequestQueueingTime,
+	MetricRequestProcessingTime,
+
+	MetricWorkerPass,
+	MetricWorkersOnline,
+	MetricWorkersUtilized,
+	MetricWorkersQueueLength,
+	MetricQueueOverflow,
+	MetricWorkersBusy,
+
+	MetricMeasuredLoad,
+	MetricControllerMeasuredLoad,
+
+	MetricControllerThreshold,
+	MetricControllerUnhealthy,
+	MetricControllerThrottlers,
+	MetricControllerGlobalThrottle,
+
+	MetricControllerLocalLoad,
+	MetricControllerSoftLimit,
+	MetricControllerHardLimit,
+
+	MetricRateLimitRejected,
+	MetricRateLimitTokensConsumed,
+	MetricRateLimitLimiters,
+	MetricControllerLimiters,
+
+	MetricChainRejected,
+	MetricChainShadowRejected,
+
+	MetricShadowDecisions,
+
+	MetricStateDropped,
+}
+
+// A key of StateReporter.State, exported as a controller.<key> gauge by
+// Simulation
+func controllerState(key, help, row string) Metric {
+	return Metric{
+		Name:     "controller." + key,
+		Kind:     MetricGauge,
+		Help:     help,
+		Labels:   []string{"edge"},
+		Row:      row,
+		Prefixed: true,
+	}
+}
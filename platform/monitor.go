@@ -0,0 +1,77 @@
+package platform
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Requests an edge has seen for a shop since it started
+type ShopCounts struct {
+	Offered  int64 `json:"offered"`
+	Admitted int64 `json:"admitted"`
+	Shed     int64 `json:"shed"`
+	// Admitted requests answered with a 503 because the work queue was full
+	Overflowed int64 `json:"overflowed"`
+	// Admitted requests answered with a 503 because the edge shut down
+	// before a worker served them
+	Unserved int64 `json:"unserved"`
+}
+
+type EdgeState struct {
+	Name       string             `json:"name"`
+	Shops      map[int]ShopCounts `json:"shops"`
+	Controller map[string]float64 `json:"controller,omitempty"` // see StateReporter
+	Throttlers []ThrottlerState   `json:"throttlers,omitempty"`
+}
+
+type WorkerState struct {
+	Online      int `json:"online"`
+	Busy        int `json:"busy"`
+	QueueLength int `json:"queue_length"`
+}
+
+// Live state of a simulation. Counts are cumulative, rates are left to the
+// reader by comparing successive snapshots.
+type Snapshot struct {
+	Time    time.Time   `json:"time"`
+	Edges   []EdgeState `json:"edges"`
+	Workers WorkerState `json:"workers"`
+}
+
+// Serves Snapshots of edges sharing a WorkerGroup as JSON
+type Monitor struct {
+	Simulations []*Simulation
+	WorkerGroup *WorkerGroup
+}
+
+func (m *Monitor) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		Time: time.Now(),
+		Workers: WorkerState{
+			Online:      m.WorkerGroup.NumWorkers,
+			Busy:        int(atomic.LoadUint32(&m.WorkerGroup.NumWorking)),
+			QueueLength: m.WorkerGroup.QueueLength(),
+		},
+	}
+
+	for _, sim := range m.Simulations {
+		edge := EdgeState{
+			Name:       sim.Name,
+			Shops:      sim.ShopCounts(),
+			Throttlers: throttlersOf(sim.AccessController),
+		}
+		if reporter, ok := sim.AccessController.(StateReporter); ok {
+			edge.Controller = reporter.State()
+		}
+		snapshot.Edges = append(snapshot.Edges, edge)
+	}
+
+	return snapshot
+}
+
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Snapshot())
+}
@@ -2,6 +2,7 @@ package platform
 
 import (
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -9,21 +10,109 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+type ThrottlerKey struct {
+	Scope  Scope
+	Origin string
+}
+
+// Scope usage is summed and published to peers at this interval rather than
+// once per request
+const scopeUsageInterval = 100 * time.Millisecond
+
+type scopeUsage struct {
+	sum   time.Duration
+	count int
+}
+
 type P1Controller struct {
 	QueueingTimeThreshold time.Duration
 	CircuitTimeout        time.Duration
 	AccessController      AccessController
 	StatsEvaluator        Tracker
 	ThrottleStrategy      string
+	Rand                  *rand.Rand // source for the throttlers' decisions
 
 	unhealthy       bool
 	unhealthyTime   time.Time
 	queueingTimeAvg time.Duration
 
-	ActiveThrottlers map[Scope]*Throttler
-	GlobalThrottler  *Throttler
+	// Kept per edge that activated them, so that an edge recovering does not
+	// lift the throttlers of another
+	ActiveThrottlers map[ThrottlerKey]*Throttler
+	GlobalThrottlers map[string]*Throttler // by origin
 
 	throttlersMut sync.RWMutex
+	statsMut      sync.Mutex
+	healthMut     sync.Mutex // guards unhealthy, unhealthyTime and queueingTimeAvg
+
+	Edge         string
+	stateBus     StateBus
+	pendingUsage map[Scope]scopeUsage // not yet published, guarded by statsMut
+
+	role string
+}
+
+// Propagates throttlers and scope usage to the other edges on the bus and
+// applies theirs locally
+func (c *P1Controller) ShareState(edge string, bus StateBus) {
+	c.statsMut.Lock()
+	c.Edge = edge
+	c.stateBus = bus
+	c.pendingUsage = make(map[Scope]scopeUsage)
+	c.statsMut.Unlock()
+
+	bus.Subscribe(edge, c.handleStateEvent)
+
+	go func() {
+		ticker := time.NewTicker(scopeUsageInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			c.publishScopeUsage()
+		}
+	}()
+}
+
+func (c *P1Controller) handleStateEvent(event StateEvent) {
+	switch event.Kind {
+	case EventThrottle:
+		throttler := &Throttler{
+			Scope:  event.Scope,
+			Rate:   event.Rate,
+			Origin: event.Source(),
+			Rand:   c.Rand,
+		}
+		if event.Global {
+			c.activateGlobalThrottler(throttler)
+		} else {
+			c.activateThrottler(throttler)
+		}
+		log.WithField("scope", event.Scope).WithField("edge", event.Source()).Info("Applying throttler from peer edge")
+	case EventClearThrottle:
+		c.clearThrottlers(event.Source())
+	case EventScopeUsage:
+		count := event.Count
+		if count < 1 {
+			count = 1
+		}
+		c.statsMut.Lock()
+		for i := 0; i < count; i++ {
+			c.StatsEvaluator.Add(event.Scope, time.Duration(event.Value)/time.Duration(count))
+		}
+		c.statsMut.Unlock()
+	}
+}
+
+func (c *P1Controller) publish(event StateEvent) {
+	if c.stateBus == nil {
+		return
+	}
+
+	event.Edge = c.Edge
+	c.stateBus.Publish(event)
+}
+
+func (c *P1Controller) SetRole(role string) {
+	c.role = role
 }
 
 func (c *P1Controller) AnalyzeRequest(req *HttpRequest) {
@@ -36,18 +125,23 @@ func (c *P1Controller) AllowAccess(req *HttpRequest) bool {
 	c.throttlersMut.RLock()
 	defer c.throttlersMut.RUnlock()
 
-	if c.GlobalThrottler != nil {
-		return c.GlobalThrottler.Allow()
+	if len(c.GlobalThrottlers) > 0 {
+		if !c.globalThrottler().Allow() {
+			req.Reason = "global_throttle"
+			return false
+		}
+		return true
 	}
 
 	if len(c.ActiveThrottlers) > 0 {
 		for _, scope := range RequestScopes(req) {
-			throttler, ok := c.ActiveThrottlers[scope]
-			if !ok {
+			throttler := c.scopeThrottler(scope)
+			if throttler == nil {
 				continue
 			}
 
 			if !throttler.Allow() {
+				req.Reason = "scope_throttle"
 				return false
 			}
 		}
@@ -56,19 +150,98 @@ func (c *P1Controller) AllowAccess(req *HttpRequest) bool {
 	return true
 }
 
+func (c *P1Controller) State() map[string]float64 {
+	c.healthMut.Lock()
+	queueingTimeAvg, unhealthy := c.queueingTimeAvg, c.unhealthy
+	c.healthMut.Unlock()
+
+	c.throttlersMut.RLock()
+	defer c.throttlersMut.RUnlock()
+
+	state := map[string]float64{
+		"measured_load":   queueingTimeAvg.Seconds(),
+		"threshold":       c.QueueingTimeThreshold.Seconds(),
+		"unhealthy":       0,
+		"throttlers":      float64(len(c.ActiveThrottlers)),
+		"global_throttle": 0,
+	}
+	if unhealthy {
+		state["unhealthy"] = 1
+	}
+	if len(c.GlobalThrottlers) > 0 {
+		state["global_throttle"] = 1
+	}
+	return state
+}
+
+func (c *P1Controller) Throttlers() []ThrottlerState {
+	c.throttlersMut.RLock()
+	defer c.throttlersMut.RUnlock()
+
+	var throttlers []ThrottlerState
+	for _, throttler := range c.GlobalThrottlers {
+		throttlers = append(throttlers, throttler.State(true))
+	}
+	for _, throttler := range c.ActiveThrottlers {
+		throttlers = append(throttlers, throttler.State(false))
+	}
+	return throttlers
+}
+
 func (c *P1Controller) activateThrottler(throttler *Throttler) {
 	c.throttlersMut.Lock()
 	defer c.throttlersMut.Unlock()
 
-	c.ActiveThrottlers[throttler.Scope] = throttler
+	if c.ActiveThrottlers == nil {
+		c.ActiveThrottlers = make(map[ThrottlerKey]*Throttler)
+	}
+	c.ActiveThrottlers[ThrottlerKey{throttler.Scope, throttler.Origin}] = throttler
+}
+
+func (c *P1Controller) activateGlobalThrottler(throttler *Throttler) {
+	c.throttlersMut.Lock()
+	defer c.throttlersMut.Unlock()
+
+	if c.GlobalThrottlers == nil {
+		c.GlobalThrottlers = make(map[string]*Throttler)
+	}
+	c.GlobalThrottlers[throttler.Origin] = throttler
+}
+
+// The strictest of the throttlers activated by any edge, for the scope or
+// globally. Must be called with throttlersMut held.
+func (c *P1Controller) scopeThrottler(scope Scope) *Throttler {
+	var strictest *Throttler
+	for key, throttler := range c.ActiveThrottlers {
+		if key.Scope == scope && (strictest == nil || throttler.Rate > strictest.Rate) {
+			strictest = throttler
+		}
+	}
+	return strictest
 }
 
-func (c *P1Controller) clearThrottlers() {
+func (c *P1Controller) globalThrottler() *Throttler {
+	var strictest *Throttler
+	for _, throttler := range c.GlobalThrottlers {
+		if strictest == nil || throttler.Rate > strictest.Rate {
+			strictest = throttler
+		}
+	}
+	return strictest
+}
+
+// Removes the throttlers activated by the given edge
+func (c *P1Controller) clearThrottlers(origin string) {
 	c.throttlersMut.Lock()
 	defer c.throttlersMut.Unlock()
 
-	c.ActiveThrottlers = make(map[Scope]*Throttler)
-	c.GlobalThrottler = nil
+	for key := range c.ActiveThrottlers {
+		if key.Origin == origin {
+			delete(c.ActiveThrottlers, key)
+		}
+	}
+
+	delete(c.GlobalThrottlers, origin)
 }
 
 func RequestScopes(req *HttpRequest) []Scope {
@@ -76,16 +249,46 @@ func RequestScopes(req *HttpRequest) []Scope {
 }
 
 func (c *P1Controller) evaluateScopeUsage(req *HttpRequest) {
+	c.statsMut.Lock()
+	defer c.statsMut.Unlock()
+
 	for _, scope := range RequestScopes(req) {
 		c.StatsEvaluator.Add(scope, req.ProcessingTime)
+
+		if c.pendingUsage != nil {
+			usage := c.pendingUsage[scope]
+			usage.sum += req.ProcessingTime
+			usage.count++
+			c.pendingUsage[scope] = usage
+		}
+	}
+}
+
+// Publishes the scope usage summed since the last call
+func (c *P1Controller) publishScopeUsage() {
+	c.statsMut.Lock()
+	pending := c.pendingUsage
+	c.pendingUsage = make(map[Scope]scopeUsage)
+	c.statsMut.Unlock()
+
+	for scope, usage := range pending {
+		c.publish(StateEvent{
+			Kind:  EventScopeUsage,
+			Scope: scope,
+			Value: float64(usage.sum),
+			Count: usage.count,
+		})
 	}
 }
 
 func (c *P1Controller) evaluatePlatformHealth(req *HttpRequest) {
+	c.healthMut.Lock()
+	defer c.healthMut.Unlock()
+
 	c.queueingTimeAvg -= c.queueingTimeAvg / 100
 	c.queueingTimeAvg += req.QueueingTime / 100
 
-	metrics.SetGauge([]string{"measured_load"}, float32(c.queueingTimeAvg.Seconds()))
+	metrics.SetGaugeWithLabels(MetricMeasuredLoad.Key(), float32(c.queueingTimeAvg.Seconds()), []metrics.Label{roleLabel(c.role)})
 
 	if c.queueingTimeAvg > c.QueueingTimeThreshold {
 		c.triggerUnhealthy()
@@ -96,13 +299,16 @@ func (c *P1Controller) evaluatePlatformHealth(req *HttpRequest) {
 	}
 }
 
+// Must be called with healthMut held
 func (c *P1Controller) triggerHealthy() {
 	c.unhealthy = false
 	c.unhealthyTime = time.Time{}
-	c.clearThrottlers()
+	c.clearThrottlers(c.Edge)
+	c.publish(StateEvent{Kind: EventClearThrottle})
 	log.Info("Recovered from high load")
 }
 
+// Must be called with healthMut held
 func (c *P1Controller) triggerUnhealthy() {
 	// TODO: use events?
 	if c.unhealthy {
@@ -114,9 +320,13 @@ func (c *P1Controller) triggerUnhealthy() {
 
 	switch c.ThrottleStrategy {
 	case "global":
-		c.GlobalThrottler = &Throttler{
-			Rate: 0.5,
+		throttler := &Throttler{
+			Rate:   0.5,
+			Origin: c.Edge,
+			Rand:   c.Rand,
 		}
+		c.activateGlobalThrottler(throttler)
+		c.publish(StateEvent{Kind: EventThrottle, Global: true, Rate: throttler.Rate})
 	case "top_hitter":
 		c.activateMaxScopeThrottler()
 	default:
@@ -125,13 +335,18 @@ func (c *P1Controller) triggerUnhealthy() {
 }
 
 func (c *P1Controller) activateMaxScopeThrottler() {
+	c.statsMut.Lock()
 	maxScope := c.StatsEvaluator.Max(1)[0]
+	c.statsMut.Unlock()
 	log.WithField("scope", maxScope).Warn("Banning scope due to high load")
 
 	throttler := &Throttler{
-		Scope: maxScope,
-		Rate:  1.0,
+		Scope:  maxScope,
+		Rate:   1.0,
+		Origin: c.Edge,
+		Rand:   c.Rand,
 	}
 
 	c.activateThrottler(throttler)
+	c.publish(StateEvent{Kind: EventThrottle, Scope: throttler.Scope, Rate: throttler.Rate})
 }
@@ -0,0 +1,51 @@
+package platform
+
+import (
+	"testing"
+)
+
+func shopRequest(shopId int) *HttpRequest {
+	return &HttpRequest{RequestHeaders: RequestHeaders{ShopId: shopId}}
+}
+
+func TestPeerRecoveryKeepsLocalThrottlers(t *testing.T) {
+	c := &P1Controller{Edge: "edge-1", Rand: NewRand(1)}
+	c.activateThrottler(&Throttler{Scope: Scope{ShopId: 1}, Rate: 1, Origin: c.Edge, Rand: c.Rand})
+
+	c.handleStateEvent(StateEvent{Edge: "edge-0", Kind: EventThrottle, Scope: Scope{ShopId: 1}, Rate: 1})
+	c.handleStateEvent(StateEvent{Edge: "edge-0", Kind: EventThrottle, Scope: Scope{ShopId: 2}, Rate: 1})
+	if c.AllowAccess(shopRequest(2)) {
+		t.Error("the peer's throttler was not applied")
+	}
+
+	c.handleStateEvent(StateEvent{Edge: "edge-0", Kind: EventClearThrottle})
+	if c.AllowAccess(shopRequest(1)) {
+		t.Error("the peer recovering lifted this edge's throttler")
+	}
+	if !c.AllowAccess(shopRequest(2)) {
+		t.Error("the peer's throttler outlived its recovery")
+	}
+}
+
+func TestPeerRecoveryKeepsLocalGlobalThrottler(t *testing.T) {
+	c := &P1Controller{Edge: "edge-1", Rand: NewRand(1)}
+	c.activateGlobalThrottler(&Throttler{Rate: 1, Origin: c.Edge, Rand: c.Rand})
+
+	c.handleStateEvent(StateEvent{Edge: "edge-0", Kind: EventThrottle, Global: true, Rate: 0})
+	if c.AllowAccess(shopRequest(1)) {
+		t.Error("a laxer peer throttler replaced this edge's")
+	}
+
+	c.handleStateEvent(StateEvent{Edge: "edge-0", Kind: EventClearThrottle})
+	if c.AllowAccess(shopRequest(1)) {
+		t.Error("the peer recovering lifted this edge's global throttler")
+	}
+	if state := c.State(); state["global_throttle"] != 1 {
+		t.Errorf("state %v, want the global throttle reported", state)
+	}
+
+	c.clearThrottlers(c.Edge)
+	if !c.AllowAccess(shopRequest(1)) {
+		t.Error("recovering did not lift the global throttler")
+	}
+}
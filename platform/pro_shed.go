@@ -41,6 +41,9 @@ type ProShed struct {
 	AccessController AccessController
 	LoadStrategy     string
 
+	// Requests of these classes are only shed once the hard limit is reached
+	ProtectedClasses []string
+
 	lastUpdate     time.Time
 	queueingLoad   float64 // in milliseconds
 	numWorkingLoad float64
@@ -48,6 +51,47 @@ type ProShed struct {
 	reqModulus     int
 
 	throttler *ProThrottler
+
+	Edge        string
+	stateBus    StateBus
+	remoteLoads map[string]remoteLoad
+
+	role string
+}
+
+type remoteLoad struct {
+	value   float64
+	updated time.Time
+}
+
+// Estimates older than this are ignored when combining loads across edges
+const remoteLoadTTL = 5 * time.Second
+
+// Publishes this edge's load estimate to its peers. The load used for
+// shedding becomes the highest fresh estimate across all edges.
+func (p *ProShed) ShareState(edge string, bus StateBus) {
+	p.LoadMut.Lock()
+	p.Edge = edge
+	p.stateBus = bus
+	p.remoteLoads = make(map[string]remoteLoad)
+	p.LoadMut.Unlock()
+
+	bus.Subscribe(edge, p.handleStateEvent)
+}
+
+func (p *ProShed) handleStateEvent(event StateEvent) {
+	if event.Kind != EventLoad {
+		return
+	}
+
+	p.LoadMut.Lock()
+	defer p.LoadMut.Unlock()
+
+	p.remoteLoads[event.Source()] = remoteLoad{event.Value, time.Now()}
+}
+
+func (p *ProShed) SetRole(role string) {
+	p.role = role
 }
 
 func (p *ProShed) AnalyzeRequest(req *HttpRequest) {
@@ -65,7 +109,44 @@ func (p *ProShed) AllowAccess(req *HttpRequest) bool {
 		return true
 	}
 
-	return p.throttler.Allow(p.SoftLimit, p.HardLimit, p.getLoad())
+	load := p.getLoad()
+
+	if p.protects(req.Class) {
+		if load < p.HardLimit {
+			return true
+		}
+		req.Reason = "load_shed"
+		return false
+	}
+
+	if !p.throttler.Allow(p.SoftLimit, p.HardLimit, load) {
+		req.Reason = "load_shed"
+		return false
+	}
+	return true
+}
+
+func (p *ProShed) State() map[string]float64 {
+	load := p.getLoad()
+
+	p.LoadMut.Lock()
+	defer p.LoadMut.Unlock()
+
+	return map[string]float64{
+		"measured_load": load,
+		"local_load":    p.localLoad(),
+		"soft_limit":    p.SoftLimit,
+		"hard_limit":    p.HardLimit,
+	}
+}
+
+func (p *ProShed) protects(class string) bool {
+	for _, protected := range p.ProtectedClasses {
+		if class == protected {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *ProShed) updateLoad(queueingTime float64, numWorking uint32) {
@@ -83,13 +164,11 @@ func (p *ProShed) updateLoad(queueingTime float64, numWorking uint32) {
 	p.numWorkingLoad += float64(numWorking) / 30
 
 	p.lastUpdate = time.Now()
-	switch p.LoadStrategy {
-	case "queueing":
-		metrics.SetGauge([]string{"measured_load"}, float32(p.queueingLoad))
-	case "num_working":
-		metrics.SetGauge([]string{"measured_load"}, float32(p.numWorkingLoad))
-	default:
-		panic("no such laod strategy")
+	load := p.localLoad()
+	metrics.SetGaugeWithLabels(MetricMeasuredLoad.Key(), float32(load), []metrics.Label{roleLabel(p.role)})
+
+	if p.stateBus != nil {
+		p.stateBus.Publish(StateEvent{Edge: p.Edge, Kind: EventLoad, Value: load})
 	}
 }
 
@@ -97,6 +176,18 @@ func (p *ProShed) getLoad() float64 {
 	p.LoadMut.Lock()
 	defer p.LoadMut.Unlock()
 
+	load := p.localLoad()
+	for _, remote := range p.remoteLoads {
+		if time.Since(remote.updated) < remoteLoadTTL && remote.value > load {
+			load = remote.value
+		}
+	}
+
+	return load
+}
+
+// Must be called with LoadMut held
+func (p *ProShed) localLoad() float64 {
 	switch p.LoadStrategy {
 	case "queueing":
 		return p.queueingLoad
@@ -0,0 +1,39 @@
+package platform
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Seeded source that is safe to share between the request goroutines
+// consulting a controller concurrently
+type lockedSource struct {
+	mut sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.src.Seed(seed)
+}
+
+// Returns a goroutine safe random number generator so that controllers can
+// make reproducible randomised decisions
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed)})
+}
+
+// Falls back to the global, unseeded source when no generator was injected
+func randFloat64(r *rand.Rand) float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	return r.Float64()
+}
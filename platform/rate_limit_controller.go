@@ -0,0 +1,137 @@
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"golang.org/x/time/rate"
+)
+
+// Token bucket parameters. A non-positive Rate means the scope is unlimited
+// and a non-positive Burst is treated as a burst of one request.
+type RateLimit struct {
+	Rate  float64 `json:"rate"` // tokens per second
+	Burst int     `json:"burst"`
+}
+
+type RateLimitConfig struct {
+	Default     RateLimit            `json:"default"`
+	Shops       map[string]RateLimit `json:"shops"` // keyed by shop id
+	MaxLimiters int                  `json:"max_limiters"`
+}
+
+func LoadRateLimitConfig(path string) (*RateLimitConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &RateLimitConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// Static per-scope quota enforced with token buckets. Limiters are kept in an
+// LRU so that the number of tracked scopes stays bounded; an evicted scope
+// starts again with a full bucket.
+type RateLimitController struct {
+	Default   RateLimit
+	Overrides map[Scope]RateLimit
+
+	mut      sync.Mutex
+	limiters *simplelru.LRU
+	role     string
+}
+
+func NewRateLimitController(config *RateLimitConfig) (*RateLimitController, error) {
+	maxLimiters := config.MaxLimiters
+	if maxLimiters <= 0 {
+		maxLimiters = 10000
+	}
+
+	limiters, err := simplelru.NewLRU(maxLimiters, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &RateLimitController{
+		Default:   config.Default,
+		Overrides: make(map[Scope]RateLimit),
+		limiters:  limiters,
+	}
+
+	for shop, limit := range config.Shops {
+		shopId, err := strconv.Atoi(shop)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shop id %q in rate limit overrides", shop)
+		}
+		c.Overrides[Scope{ShopId: shopId}] = limit
+	}
+
+	return c, nil
+}
+
+func (c *RateLimitController) AllowAccess(req *HttpRequest) bool {
+	for _, scope := range RequestScopes(req) {
+		labels := []metrics.Label{{Name: "shop_id", Value: strconv.Itoa(scope.ShopId)}, roleLabel(c.role)}
+
+		if !c.limiter(scope).Allow() {
+			req.Reason = "rate_limited"
+			metrics.IncrCounterWithLabels(MetricRateLimitRejected.Key(), 1, labels)
+			return false
+		}
+
+		metrics.IncrCounterWithLabels(MetricRateLimitTokensConsumed.Key(), 1, labels)
+	}
+
+	return true
+}
+
+func (c *RateLimitController) SetRole(role string) {
+	c.role = role
+}
+
+func (c *RateLimitController) LogAccess(req *HttpRequest) {}
+
+func (c *RateLimitController) State() map[string]float64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return map[string]float64{"limiters": float64(c.limiters.Len())}
+}
+
+func (c *RateLimitController) limiter(scope Scope) *rate.Limiter {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if limiter, ok := c.limiters.Get(scope); ok {
+		return limiter.(*rate.Limiter)
+	}
+
+	limit, ok := c.Overrides[scope]
+	if !ok {
+		limit = c.Default
+	}
+
+	var limiter *rate.Limiter
+	if limit.Rate <= 0 {
+		limiter = rate.NewLimiter(rate.Inf, 0)
+	} else if limit.Burst <= 0 {
+		limiter = rate.NewLimiter(rate.Limit(limit.Rate), 1)
+	} else {
+		limiter = rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+	}
+
+	c.limiters.Add(scope, limiter)
+	metrics.SetGaugeWithLabels(MetricRateLimitLimiters.Key(), float32(c.limiters.Len()), []metrics.Label{roleLabel(c.role)})
+
+	return limiter
+}
@@ -0,0 +1,73 @@
+package platform
+
+import (
+	"testing"
+)
+
+// Returns how many of n requests from the shop were allowed in a row
+func allowedInARow(c *RateLimitController, shopId, n int) int {
+	for i := 0; i < n; i++ {
+		if !c.AllowAccess(shopRequest(shopId)) {
+			return i
+		}
+	}
+	return n
+}
+
+func TestRateLimitBursts(t *testing.T) {
+	c, err := NewRateLimitController(&RateLimitConfig{
+		Default: RateLimit{Rate: 0.001, Burst: 2},
+		Shops: map[string]RateLimit{
+			"2": {Rate: 0},
+			"3": {Rate: 0.001, Burst: 0},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int]int{1: 2, 2: 100, 3: 1}
+	for shopId, n := range want {
+		if got := allowedInARow(c, shopId, 100); got != n {
+			t.Errorf("shop %d allowed %d requests, want %d", shopId, got, n)
+		}
+	}
+
+	req := shopRequest(1)
+	if c.AllowAccess(req) || req.Reason != "rate_limited" {
+		t.Errorf("got reason %q for an empty bucket, want rate_limited", req.Reason)
+	}
+}
+
+func TestRateLimitEvictsLeastRecentlyUsed(t *testing.T) {
+	c, err := NewRateLimitController(&RateLimitConfig{
+		Default:     RateLimit{Rate: 0.001, Burst: 1},
+		MaxLimiters: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, shopId := range []int{1, 2, 1, 3} {
+		c.AllowAccess(shopRequest(shopId))
+	}
+	if n := c.State()["limiters"]; n != 2 {
+		t.Errorf("tracking %v limiters, want 2", n)
+	}
+
+	// Shop 1 was used more recently than shop 2, so only shop 2 starts again
+	// with a full bucket
+	if c.AllowAccess(shopRequest(1)) {
+		t.Error("shop 1's limiter was evicted")
+	}
+	if !c.AllowAccess(shopRequest(2)) {
+		t.Error("shop 2's limiter was kept")
+	}
+}
+
+func TestRateLimitRejectsInvalidShops(t *testing.T) {
+	_, err := NewRateLimitController(&RateLimitConfig{Shops: map[string]RateLimit{"shop": {Rate: 1}}})
+	if err == nil {
+		t.Error("expected an error for a shop id that is not a number")
+	}
+}
@@ -0,0 +1,173 @@
+package platform
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Percentiles of the total time of admitted requests that were served, in
+// milliseconds
+type LatencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// What an edge did during one interval of a Recording
+type EdgeInterval struct {
+	Name       string             `json:"name"`
+	Shops      map[int]ShopCounts `json:"shops"` // requests during the interval
+	Latency    LatencyPercentiles `json:"latency"`
+	Controller map[string]float64 `json:"controller,omitempty"` // see StateReporter
+	Throttlers []ThrottlerState   `json:"throttlers,omitempty"`
+}
+
+type Interval struct {
+	Time    time.Time      `json:"time"` // end of the interval
+	Seconds float64        `json:"seconds"`
+	Edges   []EdgeInterval `json:"edges"`
+	Workers WorkerState    `json:"workers"`
+}
+
+type Recording struct {
+	Strategy  string     `json:"strategy"`
+	Seed      int64      `json:"seed"` // reproduces the controllers' decisions
+	Start     time.Time  `json:"start"`
+	End       time.Time  `json:"end"`
+	Intervals []Interval `json:"intervals"`
+}
+
+// Records a simulation run as a series of intervals taken from the Monitor,
+// together with the latencies of the requests the edges report to Observe
+type Recorder struct {
+	Monitor  *Monitor
+	Interval time.Duration
+	Strategy string
+	Seed     int64
+
+	mut       sync.Mutex
+	recording Recording
+	previous  Snapshot
+	latencies map[string][]float64
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func (r *Recorder) Start() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.previous = r.Monitor.Snapshot()
+	r.recording = Recording{Strategy: r.Strategy, Seed: r.Seed, Start: r.previous.Time}
+	r.latencies = make(map[string][]float64)
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.record()
+			case <-r.stop:
+				r.record()
+				return
+			}
+		}
+	}()
+}
+
+// Stops recording and returns everything recorded since Start
+func (r *Recorder) Stop() Recording {
+	close(r.stop)
+	<-r.done
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.recording
+}
+
+// Called by an edge for every request it answered
+func (r *Recorder) Observe(edge string, req *HttpRequest) {
+	if r == nil || req.Decision != DecisionAllowed || req.HttpStatus != http.StatusOK {
+		return
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.latencies != nil {
+		r.latencies[edge] = append(r.latencies[edge], milliseconds(req.TotalTime))
+	}
+}
+
+func (r *Recorder) record() {
+	snapshot := r.Monitor.Snapshot()
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	interval := Interval{
+		Time:    snapshot.Time,
+		Seconds: snapshot.Time.Sub(r.previous.Time).Seconds(),
+		Workers: snapshot.Workers,
+	}
+
+	for _, edge := range snapshot.Edges {
+		var before map[int]ShopCounts
+		for _, previous := range r.previous.Edges {
+			if previous.Name == edge.Name {
+				before = previous.Shops
+			}
+		}
+
+		shops := make(map[int]ShopCounts, len(edge.Shops))
+		for shopId, counts := range edge.Shops {
+			b := before[shopId]
+			shops[shopId] = ShopCounts{
+				Offered:    counts.Offered - b.Offered,
+				Admitted:   counts.Admitted - b.Admitted,
+				Shed:       counts.Shed - b.Shed,
+				Overflowed: counts.Overflowed - b.Overflowed,
+				Unserved:   counts.Unserved - b.Unserved,
+			}
+		}
+
+		interval.Edges = append(interval.Edges, EdgeInterval{
+			Name:       edge.Name,
+			Shops:      shops,
+			Latency:    percentilesOf(r.latencies[edge.Name]),
+			Controller: edge.Controller,
+			Throttlers: edge.Throttlers,
+		})
+	}
+
+	r.recording.End = snapshot.Time
+	r.recording.Intervals = append(r.recording.Intervals, interval)
+	r.previous = snapshot
+	r.latencies = make(map[string][]float64)
+}
+
+func percentilesOf(latencies []float64) LatencyPercentiles {
+	if len(latencies) == 0 {
+		return LatencyPercentiles{}
+	}
+
+	sort.Float64s(latencies)
+	at := func(p float64) float64 {
+		i := int(p / 100 * float64(len(latencies)))
+		if i >= len(latencies) {
+			i = len(latencies) - 1
+		}
+		return latencies[i]
+	}
+
+	return LatencyPercentiles{P50: at(50), P95: at(95), P99: at(99)}
+}
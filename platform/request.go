@@ -6,22 +6,46 @@ import (
 )
 
 type RequestStats struct {
+	ReceivedAt     time.Time
+	QueuedAt       time.Time // when the request was handed to the WorkerGroup
 	QueueingTime   time.Duration
 	ProcessingTime time.Duration
 	TotalTime      time.Duration
 	QueueLength    int
 	NumWorking     uint32
+	// Set when the request was not queued, or was dropped from the queue,
+	// because the queue was full. See WorkerGroup.OverflowPolicy.
+	QueueOverflow string
 }
 
 type RequestHeaders struct {
 	ShopId   int `json:"shop_id"`
 	ClientId string
+	Class    string // see Classify
 }
 
 type ResponseHeaders struct {
 	HttpStatus int
 }
 
+const (
+	DecisionAllowed  = "allowed"
+	DecisionRejected = "rejected"
+	DecisionInvalid  = "invalid" // the request could not be parsed
+)
+
+// Reasons an admitted request was answered with a 503
+const (
+	ReasonQueueFull    = "queue_full"
+	ReasonShuttingDown = "shutting_down"
+)
+
+type AccessDecision struct {
+	Decision   string
+	RejectedBy string // name of the chain stage that rejected the request
+	Reason     string // set by the controller that rejected the request
+}
+
 type HttpRequest struct {
 	httpReq  *http.Request
 	httpResp http.ResponseWriter
@@ -29,4 +53,5 @@ type HttpRequest struct {
 	ResponseHeaders
 	RequestStats
 	RequestHeaders
+	AccessDecision
 }
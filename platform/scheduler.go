@@ -0,0 +1,189 @@
+package platform
+
+// Orders the requests waiting in a WorkerGroup's queue
+const (
+	// First come, first served
+	SchedulerFIFO = "fifo"
+	// Checkouts before writes before reads, see ClassPriority. Requests of
+	// the same class are served in order.
+	SchedulerPriority = "priority"
+	// Deficit round robin across shops, each shop being served in proportion
+	// to its weight however many requests it queues
+	SchedulerDRR = "drr"
+)
+
+type scheduler interface {
+	push(work Work)
+	pop() (Work, bool)
+	// Removes the request dropped to make room with QueueDropOldest
+	evict() (Work, bool)
+	len() int
+}
+
+func newScheduler(name string, shopWeights map[int]int) scheduler {
+	switch name {
+	case SchedulerPriority:
+		return &priorityScheduler{queues: make([]workFIFO, numClassPriorities)}
+	case SchedulerDRR:
+		return &drrScheduler{
+			weights: shopWeights,
+			queues:  make(map[int]*workFIFO),
+			deficit: make(map[int]int),
+		}
+	default:
+		return &workFIFO{}
+	}
+}
+
+type workFIFO struct {
+	items []Work
+}
+
+func (q *workFIFO) push(work Work) {
+	q.items = append(q.items, work)
+}
+
+func (q *workFIFO) pop() (Work, bool) {
+	if len(q.items) == 0 {
+		return Work{}, false
+	}
+
+	work := q.items[0]
+	q.items[0] = Work{}
+	q.items = q.items[1:]
+	return work, true
+}
+
+// The oldest request
+func (q *workFIFO) evict() (Work, bool) {
+	return q.pop()
+}
+
+func (q *workFIFO) len() int {
+	return len(q.items)
+}
+
+type priorityScheduler struct {
+	queues []workFIFO // by priority
+	size   int
+}
+
+func (s *priorityScheduler) push(work Work) {
+	s.queues[ClassPriority(work.Request.Class)].push(work)
+	s.size++
+}
+
+func (s *priorityScheduler) pop() (Work, bool) {
+	for i := range s.queues {
+		if work, ok := s.queues[i].pop(); ok {
+			s.size--
+			return work, true
+		}
+	}
+	return Work{}, false
+}
+
+// The oldest request of the lowest priority
+func (s *priorityScheduler) evict() (Work, bool) {
+	for i := len(s.queues) - 1; i >= 0; i-- {
+		if work, ok := s.queues[i].pop(); ok {
+			s.size--
+			return work, true
+		}
+	}
+	return Work{}, false
+}
+
+func (s *priorityScheduler) len() int {
+	return s.size
+}
+
+// Every request costs the same, so a shop is served up to its weight in
+// requests, 1 by default, each time its turn comes around
+type drrScheduler struct {
+	weights map[int]int
+	queues  map[int]*workFIFO
+	active  []int // shops with queued requests, in the order of their turns
+	deficit map[int]int
+	size    int
+}
+
+func (s *drrScheduler) push(work Work) {
+	shopId := work.Request.ShopId
+
+	queue, ok := s.queues[shopId]
+	if !ok {
+		queue = &workFIFO{}
+		s.queues[shopId] = queue
+		s.active = append(s.active, shopId)
+	}
+
+	queue.push(work)
+	s.size++
+}
+
+func (s *drrScheduler) pop() (Work, bool) {
+	if len(s.active) == 0 {
+		return Work{}, false
+	}
+
+	shopId := s.active[0]
+	if s.deficit[shopId] <= 0 {
+		s.deficit[shopId] = s.weight(shopId)
+	}
+
+	queue := s.queues[shopId]
+	work, _ := queue.pop()
+	s.deficit[shopId]--
+	s.size--
+
+	if queue.len() == 0 {
+		s.remove(0)
+	} else if s.deficit[shopId] <= 0 {
+		// Its turn is over
+		s.active = append(s.active[1:], shopId)
+	}
+
+	return work, true
+}
+
+// The oldest request of the shop with the longest queue for its weight
+func (s *drrScheduler) evict() (Work, bool) {
+	longest := -1
+	for i, shopId := range s.active {
+		if longest < 0 || s.queues[shopId].len()*s.weight(s.active[longest]) > s.queues[s.active[longest]].len()*s.weight(shopId) {
+			longest = i
+		}
+	}
+	if longest < 0 {
+		return Work{}, false
+	}
+
+	queue := s.queues[s.active[longest]]
+	work, _ := queue.pop()
+	s.size--
+
+	if queue.len() == 0 {
+		s.remove(longest)
+	}
+	return work, true
+}
+
+func (s *drrScheduler) len() int {
+	return s.size
+}
+
+func (s *drrScheduler) weight(shopId int) int {
+	if weight, ok := s.weights[shopId]; ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// Forgets an active shop whose queue is empty
+func (s *drrScheduler) remove(i int) {
+	shopId := s.active[i]
+	delete(s.queues, shopId)
+	delete(s.deficit, shopId)
+	s.active = append(s.active[:i], s.active[i+1:]...)
+}
@@ -0,0 +1,118 @@
+package platform
+
+import (
+	"reflect"
+	"testing"
+)
+
+func work(shopId int, class string) Work {
+	return Work{Request: &HttpRequest{RequestHeaders: RequestHeaders{ShopId: shopId, Class: class}}}
+}
+
+func drain(s scheduler, next func(scheduler) (Work, bool)) []*HttpRequest {
+	var order []*HttpRequest
+	for {
+		w, ok := next(s)
+		if !ok {
+			return order
+		}
+		order = append(order, w.Request)
+	}
+}
+
+func pop(s scheduler) (Work, bool) {
+	return s.pop()
+}
+
+func evict(s scheduler) (Work, bool) {
+	return s.evict()
+}
+
+func classes(reqs []*HttpRequest) []string {
+	var result []string
+	for _, req := range reqs {
+		result = append(result, req.Class)
+	}
+	return result
+}
+
+func shops(reqs []*HttpRequest) []int {
+	var result []int
+	for _, req := range reqs {
+		result = append(result, req.ShopId)
+	}
+	return result
+}
+
+func TestPrioritySchedulerServesCheckoutsFirst(t *testing.T) {
+	s := newScheduler(SchedulerPriority, nil)
+	for _, class := range []string{ClassRead, ClassWrite, ClassCheckout, ClassRead, ClassCheckout} {
+		s.push(work(1, class))
+	}
+	if s.len() != 5 {
+		t.Fatalf("len is %d, want 5", s.len())
+	}
+
+	want := []string{ClassCheckout, ClassCheckout, ClassWrite, ClassRead, ClassRead}
+	if got := classes(drain(s, pop)); !reflect.DeepEqual(got, want) {
+		t.Errorf("served %v, want %v", got, want)
+	}
+}
+
+func TestPrioritySchedulerEvictsLowestClass(t *testing.T) {
+	s := newScheduler(SchedulerPriority, nil)
+	first := work(1, ClassRead)
+	s.push(work(1, ClassCheckout))
+	s.push(first)
+	s.push(work(2, ClassRead))
+
+	evicted, ok := s.evict()
+	if !ok || evicted.Request != first.Request {
+		t.Errorf("evicted %+v, want the oldest read", evicted.Request)
+	}
+}
+
+func TestDRRSchedulerSharesByWeight(t *testing.T) {
+	s := newScheduler(SchedulerDRR, map[int]int{1: 2})
+	for i := 0; i < 4; i++ {
+		s.push(work(1, ClassRead))
+	}
+	for i := 0; i < 3; i++ {
+		s.push(work(2, ClassRead))
+	}
+
+	want := []int{1, 1, 2, 1, 1, 2, 2}
+	if got := shops(drain(s, pop)); !reflect.DeepEqual(got, want) {
+		t.Errorf("served shops %v, want %v", got, want)
+	}
+	if s.len() != 0 {
+		t.Errorf("len is %d after draining", s.len())
+	}
+}
+
+func TestDRRSchedulerEvictsLongestWeightedQueue(t *testing.T) {
+	s := newScheduler(SchedulerDRR, map[int]int{1: 4})
+	for i := 0; i < 4; i++ {
+		s.push(work(1, ClassRead))
+	}
+	s.push(work(2, ClassRead))
+	s.push(work(2, ClassRead))
+
+	// Shop 2 queues 2 requests for a weight of 1 against shop 1's 4 for 4.
+	// Ties go to the shop that queued first.
+	want := []int{2, 1, 2, 1, 1, 1}
+	if got := shops(drain(s, evict)); !reflect.DeepEqual(got, want) {
+		t.Errorf("evicted shops %v, want %v", got, want)
+	}
+}
+
+func TestFIFOScheduler(t *testing.T) {
+	s := newScheduler(SchedulerFIFO, nil)
+	for _, shopId := range []int{3, 1, 2} {
+		s.push(work(shopId, ClassRead))
+	}
+
+	if got := shops(drain(s, pop)); !reflect.DeepEqual(got, []int{3, 1, 2}) {
+		t.Errorf("served shops %v, want them in order", got)
+	}
+}
@@ -0,0 +1,159 @@
+package platform
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// Agreement between the active and the shadow controller for one scope
+type ShadowCounts struct {
+	BothAllowed        int `json:"both_allowed"`
+	BothRejected       int `json:"both_rejected"`
+	ShadowOnlyRejected int `json:"shadow_only_rejected"`
+	ActiveOnlyRejected int `json:"active_only_rejected"`
+}
+
+func (c ShadowCounts) Agree() int {
+	return c.BothAllowed + c.BothRejected
+}
+
+func (c ShadowCounts) Disagree() int {
+	return c.ShadowOnlyRejected + c.ActiveOnlyRejected
+}
+
+// Runs a candidate controller in dry-run mode next to the active one. The
+// shadow sees every request but only the active controller's decisions are
+// enforced. The shadow never shares its state with other edges so that it
+// cannot influence their decisions either.
+type ShadowController struct {
+	Active AccessController
+	Shadow AccessController
+	Name   string // identifies the shadow in metrics
+
+	mut    sync.Mutex
+	counts map[Scope]*ShadowCounts
+}
+
+func NewShadowController(active, shadow AccessController, name string) *ShadowController {
+	SetRole(shadow, RoleShadow)
+
+	return &ShadowController{
+		Active: active,
+		Shadow: shadow,
+		Name:   name,
+	}
+}
+
+func (c *ShadowController) AllowAccess(req *HttpRequest) bool {
+	active := c.Active.AllowAccess(req)
+
+	decision := req.AccessDecision
+	shadow := c.Shadow.AllowAccess(req)
+	req.AccessDecision = decision
+
+	c.record(req, active, shadow)
+	return active
+}
+
+func (c *ShadowController) LogAccess(req *HttpRequest) {
+	c.Active.LogAccess(req)
+	c.Shadow.LogAccess(req)
+}
+
+func (c *ShadowController) ShareState(edge string, bus StateBus) {
+	if sharer, ok := c.Active.(StateSharer); ok {
+		sharer.ShareState(edge, bus)
+	}
+}
+
+// The active controller's state, and the shadow's prefixed with "shadow"
+func (c *ShadowController) State() map[string]float64 {
+	state := make(map[string]float64)
+	addState(state, "", c.Active)
+	addState(state, "shadow", c.Shadow)
+	return state
+}
+
+// Only the active controller's throttlers affect traffic
+func (c *ShadowController) Throttlers() []ThrottlerState {
+	return throttlersOf(c.Active)
+}
+
+func (c *ShadowController) record(req *HttpRequest, active, shadow bool) {
+	outcome := "agree"
+	if active != shadow {
+		outcome = "disagree"
+	}
+
+	labels := []metrics.Label{
+		{Name: "shadow", Value: c.Name},
+		{Name: "outcome", Value: outcome},
+		{Name: "shadow_allowed", Value: fmt.Sprintf("%t", shadow)},
+	}
+	metrics.IncrCounterWithLabels(MetricShadowDecisions.Key(), 1, labels)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[Scope]*ShadowCounts)
+	}
+
+	for _, scope := range RequestScopes(req) {
+		counts, ok := c.counts[scope]
+		if !ok {
+			counts = &ShadowCounts{}
+			c.counts[scope] = counts
+		}
+
+		switch {
+		case active && shadow:
+			counts.BothAllowed++
+		case !active && !shadow:
+			counts.BothRejected++
+		case active:
+			counts.ShadowOnlyRejected++
+		default:
+			counts.ActiveOnlyRejected++
+		}
+	}
+}
+
+func (c *ShadowController) Summary() map[Scope]ShadowCounts {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	summary := make(map[Scope]ShadowCounts, len(c.counts))
+	for scope, counts := range c.counts {
+		summary[scope] = *counts
+	}
+
+	return summary
+}
+
+// Writes the agree/disagree counts per scope as a table
+func (c *ShadowController) WriteSummary(w io.Writer) error {
+	summary := c.Summary()
+
+	scopes := make([]Scope, 0, len(summary))
+	for scope := range summary {
+		scopes = append(scopes, scope)
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i].ShopId < scopes[j].ShopId })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "shop\tagree\tdisagree\tboth allowed\tboth rejected\tshadow only rejected\tactive only rejected\t\n")
+	for _, scope := range scopes {
+		counts := summary[scope]
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t\n",
+			scope.ShopId, counts.Agree(), counts.Disagree(),
+			counts.BothAllowed, counts.BothRejected, counts.ShadowOnlyRejected, counts.ActiveOnlyRejected)
+	}
+
+	return tw.Flush()
+}
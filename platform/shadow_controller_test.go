@@ -0,0 +1,86 @@
+package platform
+
+import (
+	"testing"
+
+	"github.com/hkdsun/simiload/telemetry"
+)
+
+func TestShadowControllerCounts(t *testing.T) {
+	active := shopDenier{shopId: 1}
+	shadow := &ChainController{
+		Mode:   ChainFirstDeny,
+		Stages: []ChainStage{{Name: "deny_2", Controller: shopDenier{shopId: 2}}},
+	}
+	c := NewShadowController(active, shadow, "candidate")
+
+	// Shop 1 is rejected by the active controller only, shop 2 by the shadow
+	// only and shop 3 by neither
+	for _, shopId := range []int{1, 1, 2, 3, 3, 3} {
+		req := shopRequest(shopId)
+		if allowed := c.AllowAccess(req); allowed != (shopId != 1) {
+			t.Errorf("shop %d allowed: %t, want the active controller's decision", shopId, allowed)
+		}
+		if req.RejectedBy != "" {
+			t.Errorf("shop %d carries the shadow's decision %q", shopId, req.RejectedBy)
+		}
+	}
+
+	want := map[Scope]ShadowCounts{
+		Scope{ShopId: 1}: {ActiveOnlyRejected: 2},
+		Scope{ShopId: 2}: {ShadowOnlyRejected: 1},
+		Scope{ShopId: 3}: {BothAllowed: 3},
+	}
+	summary := c.Summary()
+	for scope, counts := range want {
+		if summary[scope] != counts {
+			t.Errorf("shop %d counted %+v, want %+v", scope.ShopId, summary[scope], counts)
+		}
+	}
+
+	agree, disagree := 0, 0
+	for _, counts := range summary {
+		agree += counts.Agree()
+		disagree += counts.Disagree()
+	}
+	if agree != 3 || disagree != 3 {
+		t.Errorf("%d agreed and %d disagreed, want 3 each", agree, disagree)
+	}
+}
+
+func TestShadowChainRejectionsLabelledByRole(t *testing.T) {
+	sinks, err := telemetry.Setup(MetricService, telemetry.Config{Sinks: []string{telemetry.SinkInmem}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sinks.Close()
+
+	newChain := func() *ChainController {
+		return &ChainController{
+			Mode: ChainShadow,
+			Stages: []ChainStage{
+				{Name: "deny_1", Controller: shopDenier{shopId: 1}},
+				{Name: "deny_2", Controller: shopDenier{shopId: 2}},
+			},
+		}
+	}
+	c := NewShadowController(newChain(), newChain(), "candidate")
+	for _, shopId := range []int{1, 2} {
+		c.AllowAccess(shopRequest(shopId))
+	}
+
+	counts := make(map[string]float64)
+	for _, interval := range sinks.Inmem.Data() {
+		for _, counter := range interval.Counters {
+			counts[counter.Name+"/"+labelValue(counter.Labels, "role")] += counter.Sum
+		}
+	}
+
+	for _, metric := range []Metric{MetricChainRejected, MetricChainShadowRejected} {
+		for _, role := range []string{RoleActive, RoleShadow} {
+			if n := counts[MetricService+"."+metric.Name+"/"+role]; n != 1 {
+				t.Errorf("counted %v %s with role %s, want 1", n, metric.Name, role)
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	EventThrottle      = "throttle"
+	EventClearThrottle = "clear_throttle"
+	EventScopeUsage    = "scope_usage"
+	EventLoad          = "load"
+)
+
+// A piece of controller state broadcast by one edge to its peers
+type StateEvent struct {
+	Edge   string  `json:"edge"`
+	Kind   string  `json:"kind"`
+	Scope  Scope   `json:"scope"`
+	Global bool    `json:"global,omitempty"`
+	Rate   float32 `json:"rate,omitempty"`
+	Value  float64 `json:"value,omitempty"`
+	Count  int     `json:"count,omitempty"` // requests summed into Value
+	// The process the event was gossiped from, empty for events published in
+	// this process. Edges in different processes can share a name.
+	Origin string `json:"origin,omitempty"`
+}
+
+// Identifies the publishing edge across processes
+func (e StateEvent) Source() string {
+	if e.Origin == "" {
+		return e.Edge
+	}
+	return e.Edge + "@" + e.Origin
+}
+
+// Propagates ban decisions and per-scope counters between edges. Handlers
+// never receive events that were published by their own edge, but do receive
+// those of a peer process's edge with the same name.
+type StateBus interface {
+	Publish(event StateEvent)
+	Subscribe(edge string, handler func(StateEvent))
+}
+
+// Implemented by controllers and analyzers that can share their state with
+// other edges
+type StateSharer interface {
+	ShareState(edge string, bus StateBus)
+}
+
+type delayedEvent struct {
+	event     StateEvent
+	deliverAt time.Time
+}
+
+// Delivers events to a single handler in publish order, each one no earlier
+// than the propagation delay after it was published
+type stateSubscriber struct {
+	edge    string
+	handler func(StateEvent)
+	queue   chan delayedEvent
+}
+
+func newStateSubscriber(edge string, handler func(StateEvent)) *stateSubscriber {
+	sub := &stateSubscriber{
+		edge:    edge,
+		handler: handler,
+		queue:   make(chan delayedEvent, 1000),
+	}
+
+	go func() {
+		for e := range sub.queue {
+			time.Sleep(time.Until(e.deliverAt))
+			sub.handler(e.event)
+		}
+	}()
+
+	return sub
+}
+
+func (s *stateSubscriber) deliver(event StateEvent, delay time.Duration) {
+	select {
+	case s.queue <- delayedEvent{event, time.Now().Add(delay)}:
+	default:
+		metrics.IncrCounterWithLabels(MetricStateDropped.Key(), 1, []metrics.Label{{Name: "edge", Value: s.edge}})
+	}
+}
+
+// Shares state between edges running in the same process
+type InMemoryStateBus struct {
+	PropagationDelay time.Duration
+
+	mut         sync.RWMutex
+	subscribers []*stateSubscriber
+}
+
+func NewInMemoryStateBus(propagationDelay time.Duration) *InMemoryStateBus {
+	return &InMemoryStateBus{
+		PropagationDelay: propagationDelay,
+	}
+}
+
+func (b *InMemoryStateBus) Publish(event StateEvent) {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	for _, sub := range b.subscribers {
+		if sub.edge == event.Edge && event.Origin == "" {
+			continue
+		}
+		sub.deliver(event, b.PropagationDelay)
+	}
+}
+
+func (b *InMemoryStateBus) Subscribe(edge string, handler func(StateEvent)) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.subscribers = append(b.subscribers, newStateSubscriber(edge, handler))
+}
+
+// Full-mesh gossip over UDP for edges running in separate processes. Every
+// event is sent to all peers as a single JSON datagram and also handed to
+// subscribers of other edges in this process.
+type GossipStateBus struct {
+	PropagationDelay time.Duration
+
+	local  *InMemoryStateBus
+	conn   *net.UDPConn
+	peers  []*net.UDPAddr
+	origin string // identifies this process to its peers
+}
+
+func NewGossipStateBus(listenAddr string, peers []string, propagationDelay time.Duration) (*GossipStateBus, error) {
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &GossipStateBus{
+		PropagationDelay: propagationDelay,
+		local:            NewInMemoryStateBus(propagationDelay),
+		conn:             conn,
+		origin:           processOrigin(),
+	}
+
+	for _, peer := range peers {
+		peerAddr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		b.peers = append(b.peers, peerAddr)
+	}
+
+	go b.receive()
+
+	log.WithField("addr", conn.LocalAddr()).WithField("peers", peers).Info("Gossiping controller state")
+	return b, nil
+}
+
+func (b *GossipStateBus) Publish(event StateEvent) {
+	b.local.Publish(event)
+
+	event.Origin = b.origin
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Error("unable to encode state event")
+		return
+	}
+
+	for _, peer := range b.peers {
+		if _, err := b.conn.WriteToUDP(payload, peer); err != nil {
+			log.WithError(err).WithField("peer", peer).Warn("unable to gossip state event")
+		}
+	}
+}
+
+func (b *GossipStateBus) Subscribe(edge string, handler func(StateEvent)) {
+	b.local.Subscribe(edge, handler)
+}
+
+func (b *GossipStateBus) Close() error {
+	return b.conn.Close()
+}
+
+func (b *GossipStateBus) receive() {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, _, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		var event StateEvent
+		if err := json.Unmarshal(buf[:n], &event); err != nil {
+			log.WithError(err).Warn("discarding malformed state event")
+			continue
+		}
+		if event.Origin == b.origin {
+			continue
+		}
+
+		b.local.Publish(event)
+	}
+}
+
+func processOrigin() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
@@ -0,0 +1,164 @@
+package platform
+
+import (
+	"testing"
+	"time"
+)
+
+// Counts the requests and time added per scope
+type usageTracker struct {
+	count map[Scope]int
+	sum   map[Scope]time.Duration
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{
+		count: make(map[Scope]int),
+		sum:   make(map[Scope]time.Duration),
+	}
+}
+
+func (u *usageTracker) Add(scope Scope, dur time.Duration) {
+	u.count[scope]++
+	u.sum[scope] += dur
+}
+
+func (u *usageTracker) Max(k int) []Scope {
+	return []Scope{Scope{}}
+}
+
+// Polls cond until it holds or a second has passed
+func eventually(cond func() bool) bool {
+	deadline := time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestInMemoryStateBusSkipsOwnEdge(t *testing.T) {
+	bus := NewInMemoryStateBus(0)
+
+	received := make(map[string]chan StateEvent)
+	for _, edge := range []string{"edge-0", "edge-1"} {
+		ch := make(chan StateEvent, 10)
+		received[edge] = ch
+		bus.Subscribe(edge, func(event StateEvent) { ch <- event })
+	}
+
+	bus.Publish(StateEvent{Edge: "edge-0", Kind: EventLoad, Value: 1})
+	// The same edge name in a peer process
+	bus.Publish(StateEvent{Edge: "edge-0", Kind: EventLoad, Value: 2, Origin: "peer:1"})
+
+	for _, want := range []float64{1, 2} {
+		select {
+		case event := <-received["edge-1"]:
+			if event.Value != want {
+				t.Errorf("edge-1 received %v, want %v", event.Value, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("edge-1 did not receive event %v", want)
+		}
+	}
+
+	select {
+	case event := <-received["edge-0"]:
+		if event.Source() != "edge-0@peer:1" {
+			t.Errorf("edge-0 received its own event %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("edge-0 did not receive the peer process's event")
+	}
+	select {
+	case event := <-received["edge-0"]:
+		t.Errorf("edge-0 received an unexpected event %v", event)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestP1ControllersShareThrottlers(t *testing.T) {
+	bus := NewInMemoryStateBus(0)
+
+	controllers := make([]*P1Controller, 2)
+	for i, edge := range []string{"edge-0", "edge-1"} {
+		controllers[i] = &P1Controller{
+			QueueingTimeThreshold: 1 * time.Millisecond,
+			CircuitTimeout:        1 * time.Hour,
+			StatsEvaluator:        newUsageTracker(),
+			ThrottleStrategy:      "global",
+			Rand:                  NewRand(int64(i)),
+		}
+		controllers[i].ShareState(edge, bus)
+	}
+	local, peer := controllers[0], controllers[1]
+
+	local.AnalyzeRequest(&HttpRequest{QueueingTime: 1 * time.Second})
+
+	ok := eventually(func() bool {
+		peer.throttlersMut.RLock()
+		defer peer.throttlersMut.RUnlock()
+		return peer.GlobalThrottlers["edge-0"] != nil
+	})
+	if !ok {
+		t.Fatal("the peer did not apply the global throttler")
+	}
+
+	local.healthMut.Lock()
+	local.triggerHealthy()
+	local.healthMut.Unlock()
+
+	ok = eventually(func() bool {
+		peer.throttlersMut.RLock()
+		defer peer.throttlersMut.RUnlock()
+		return len(peer.GlobalThrottlers) == 0
+	})
+	if !ok {
+		t.Error("the peer kept the global throttler after recovery")
+	}
+}
+
+func TestP1ControllersShareScopeUsage(t *testing.T) {
+	bus := NewInMemoryStateBus(0)
+	published := make(chan StateEvent, 100)
+	bus.Subscribe("observer", func(event StateEvent) { published <- event })
+
+	local := &P1Controller{StatsEvaluator: newUsageTracker()}
+	local.ShareState("edge-0", bus)
+	peerTracker := newUsageTracker()
+	peer := &P1Controller{StatsEvaluator: peerTracker}
+	peer.ShareState("edge-1", bus)
+
+	for i := 0; i < 10; i++ {
+		local.evaluateScopeUsage(&HttpRequest{
+			RequestHeaders: RequestHeaders{ShopId: 1},
+			ProcessingTime: 10 * time.Millisecond,
+		})
+	}
+
+	ok := eventually(func() bool {
+		peer.statsMut.Lock()
+		defer peer.statsMut.Unlock()
+		return peerTracker.count[Scope{ShopId: 1}] == 10
+	})
+	if !ok {
+		t.Fatalf("the peer counted %d requests, want 10", peerTracker.count[Scope{ShopId: 1}])
+	}
+	peer.statsMut.Lock()
+	if sum := peerTracker.sum[Scope{ShopId: 1}]; sum != 100*time.Millisecond {
+		t.Errorf("the peer added %v, want 100ms", sum)
+	}
+	peer.statsMut.Unlock()
+
+	// The requests were published in batches, not one event each
+	events, count := 0, 0
+	for count < 10 {
+		count += (<-published).Count
+		events++
+	}
+	if events > 2 {
+		t.Errorf("published 10 requests in %d events", events)
+	}
+}
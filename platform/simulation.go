@@ -1,6 +1,7 @@
 package platform
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	metrics "github.com/armon/go-metrics"
+	"github.com/hkdsun/simiload/tracing"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,12 +18,23 @@ import (
 // ultimately its response throughput is bottlenecked by its WorkerGroup
 // throughput
 type Simulation struct {
+	Name                 string
 	WorkerGroup          *WorkerGroup
 	Port                 uint
 	AccessController     AccessController
 	RequestSamplingDelay time.Duration
+	AccessLog            *AccessLog      // optional
+	Tracer               *tracing.Tracer // optional
+	Recorder             *Recorder       // optional
+	// How long Run waits for requests in flight once its context is done
+	DrainTimeout time.Duration
 
 	logQueue ReqQueue
+	loggerWg *sync.WaitGroup
+	inFlight sync.WaitGroup
+
+	countsMut sync.Mutex
+	counts    map[int]*ShopCounts
 }
 
 func (s *Simulation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -29,88 +42,295 @@ func (s *Simulation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		httpReq:  r,
 		httpResp: w,
 	}
+	request.ReceivedAt = time.Now()
+	span := s.startSpan(request)
+
+	s.inFlight.Add(1)
+	defer s.inFlight.Done()
 
 	defer func() {
+		if request.TotalTime == 0 {
+			// Requests answered by the edge never reach the WorkerGroup
+			request.TotalTime = time.Since(request.ReceivedAt)
+		}
+		s.emitRequestMetrics(request)
+		s.countRequest(request)
+		s.Recorder.Observe(s.Name, request)
+		s.finishSpan(span, request)
+
+		s.inFlight.Add(1)
 		go func() {
+			defer s.inFlight.Done()
 			time.Sleep(s.RequestSamplingDelay)
 			s.logQueue <- request
 		}()
 	}()
 
+	var action string
 	if strings.HasPrefix(r.URL.Path, "/shop") {
-		split := strings.Split(r.URL.Path, "/")
-
-		request.ClientId = split[len(split)-1]
-
-		shopId, err := strconv.Atoi(split[len(split)-2])
+		shopId, clientId, pathAction, err := parseShopPath(r.URL.Path)
 		if err != nil {
 			log.WithError(err).Error("unable to parse shopid")
 			w.WriteHeader(500)
 			request.HttpStatus = 500
+			request.Decision = DecisionInvalid
 			return
 		}
 
 		request.ShopId = shopId
+		request.ClientId = clientId
+		action = pathAction
 	}
 
+	request.Class = Classify(r, action)
+
 	if !s.AccessController.AllowAccess(request) {
 		w.WriteHeader(http.StatusTooManyRequests)
 		request.HttpStatus = http.StatusTooManyRequests
+		request.Decision = DecisionRejected
 		labels := []metrics.Label{
-			{"shop_id", fmt.Sprintf("%d", request.ShopId)},
-			{"client_id", request.ClientId},
+			{Name: "shop_id", Value: strconv.Itoa(request.ShopId)},
+			{Name: "client_id", Value: request.ClientId},
+			{Name: "class", Value: request.Class},
 		}
-		metrics.IncrCounterWithLabels([]string{"request.edge.dropped"}, 1, labels)
+		metrics.IncrCounterWithLabels(MetricRequestDropped.Key(), 1, labels)
 		return
 	} else {
+		request.Decision = DecisionAllowed
 		labels := []metrics.Label{
-			{"shop_id", fmt.Sprintf("%d", request.ShopId)},
-			{"client_id", request.ClientId},
+			{Name: "shop_id", Value: strconv.Itoa(request.ShopId)},
+			{Name: "client_id", Value: request.ClientId},
+			{Name: "class", Value: request.Class},
 		}
-		metrics.IncrCounterWithLabels([]string{"request.edge.passed"}, 1, labels)
+		metrics.IncrCounterWithLabels(MetricRequestPassed.Key(), 1, labels)
 	}
 
-	s.WorkerGroup.Serve(request)
+	if !s.WorkerGroup.Serve(request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		request.HttpStatus = http.StatusServiceUnavailable
+		request.Reason = ReasonShuttingDown
+		if request.QueueOverflow != "" {
+			request.Reason = ReasonQueueFull
+		}
+		return
+	}
 
 	request.HttpStatus = 200
-
-	s.emitRequestMetrics(request)
 }
 
-func (s *Simulation) Run() {
+// Serves requests until ctx is done, then stops accepting them and waits up
+// to DrainTimeout for the requests in flight. Call Close once the WorkerGroup
+// has stopped as well.
+func (s *Simulation) Run(ctx context.Context) error {
 	s.logQueue = make(ReqQueue, 1000)
+	s.loggerWg = s.startRequestLogger(s.logQueue)
 
-	loggerWg := s.startRequestLogger(s.logQueue)
-	defer loggerWg.Wait()
+	if reporter, ok := s.AccessController.(StateReporter); ok {
+		go s.reportState(ctx, reporter)
+	}
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.Port),
 		Handler: s,
 	}
 
-	log.Infof("Starting HTTP server on port %d", s.Port)
-	log.Fatal(server.ListenAndServe())
+	errs := make(chan error, 1)
+	go func() {
+		log.Infof("Starting HTTP server on port %d", s.Port)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), s.DrainTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		log.WithError(err).Warnf("%s did not drain within %s", s.Name, s.DrainTimeout)
+		server.Close()
+	}
+	return nil
+}
+
+// Waits for the requests still in flight and writes their access log
+// entries. Requests can only finish once the WorkerGroup served them or
+// stopped.
+func (s *Simulation) Close() {
+	s.inFlight.Wait()
+	close(s.logQueue)
+	s.loggerWg.Wait()
 }
 
 func (s *Simulation) startRequestLogger(logQueue ReqQueue) *sync.WaitGroup {
 	wg := &sync.WaitGroup{}
-	wg.Add(2)
+	wg.Add(1)
 
 	go func() {
-		for {
-			request, ok := <-logQueue
-			if !ok {
-				break
-			}
+		defer wg.Done()
+
+		for request := range logQueue {
 			s.AccessController.LogAccess(request)
+
+			if s.AccessLog != nil {
+				if err := s.AccessLog.Write(NewAccessLogEntry(s.Name, request)); err != nil {
+					log.WithError(err).Error("unable to write access log")
+				}
+			}
 		}
 	}()
 
 	return wg
 }
 
+// Every request is counted by status. Times are histograms in milliseconds;
+// queueing and processing times only exist for admitted requests.
 func (s *Simulation) emitRequestMetrics(req *HttpRequest) {
-	metrics.AddSample([]string{"request.processing_time"}, float32(req.ProcessingTime.Seconds()*1000))
-	metrics.AddSample([]string{"request.queueing_time"}, float32(req.QueueingTime.Seconds()*1000))
-	metrics.IncrCounterWithLabels([]string{"request.count"}, 1, []metrics.Label{{"status", string(req.HttpStatus)}})
+	metrics.IncrCounterWithLabels(MetricRequestCount.Key(), 1, []metrics.Label{
+		{Name: "edge", Value: s.Name},
+		{Name: "status", Value: strconv.Itoa(req.HttpStatus)},
+		{Name: "decision", Value: req.Decision},
+		{Name: "class", Value: req.Class},
+	})
+
+	labels := []metrics.Label{
+		{Name: "shop_id", Value: strconv.Itoa(req.ShopId)},
+		{Name: "decision", Value: req.Decision},
+	}
+	metrics.AddSampleWithLabels(MetricRequestTotalTime.Key(), float32(milliseconds(req.TotalTime)), labels)
+
+	if req.Decision == DecisionAllowed {
+		metrics.AddSampleWithLabels(MetricRequestQueueingTime.Key(), float32(milliseconds(req.QueueingTime)), labels)
+		metrics.AddSampleWithLabels(MetricRequestProcessingTime.Key(), float32(milliseconds(req.ProcessingTime)), labels)
+	}
+}
+
+// Exports the controller's state as controller.* gauges once a second
+func (s *Simulation) reportState(ctx context.Context, reporter StateReporter) {
+	labels := []metrics.Label{{Name: "edge", Value: s.Name}}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for name, value := range reporter.State() {
+				metrics.SetGaugeWithLabels([]string{"controller", name}, float32(value), labels)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Simulation) countRequest(req *HttpRequest) {
+	if req.Decision == DecisionInvalid {
+		return
+	}
+
+	s.countsMut.Lock()
+	defer s.countsMut.Unlock()
+
+	if s.counts == nil {
+		s.counts = make(map[int]*ShopCounts)
+	}
+	counts, ok := s.counts[req.ShopId]
+	if !ok {
+		counts = &ShopCounts{}
+		s.counts[req.ShopId] = counts
+	}
+
+	counts.Offered++
+	if req.Decision == DecisionAllowed {
+		counts.Admitted++
+	} else {
+		counts.Shed++
+	}
+	if req.QueueOverflow != "" {
+		counts.Overflowed++
+	}
+	if req.Reason == ReasonShuttingDown {
+		counts.Unserved++
+	}
+}
+
+// Requests seen per shop since the edge started
+func (s *Simulation) ShopCounts() map[int]ShopCounts {
+	s.countsMut.Lock()
+	defer s.countsMut.Unlock()
+
+	counts := make(map[int]ShopCounts, len(s.counts))
+	for shop, c := range s.counts {
+		counts[shop] = *c
+	}
+	return counts
+}
+
+// Continues the trace of the request's traceparent header, if any
+func (s *Simulation) startSpan(req *HttpRequest) *tracing.Span {
+	if s.Tracer == nil {
+		return nil
+	}
+
+	var parent tracing.SpanContext
+	if header := req.httpReq.Header.Get(tracing.TraceparentHeader); header != "" {
+		var err error
+		if parent, err = tracing.ParseTraceparent(header); err != nil {
+			log.WithError(err).Debug("ignoring invalid traceparent")
+		}
+	}
+
+	span := s.Tracer.StartSpan("edge", parent, req.ReceivedAt)
+	span.SetKind(tracing.SpanKindServer)
+	return span
+}
+
+// Records the access decision on the edge span, and the time spent queueing
+// and working as its children
+func (s *Simulation) finishSpan(span *tracing.Span, req *HttpRequest) {
+	if span == nil {
+		return
+	}
+
+	span.SetAttribute("edge", s.Name)
+	span.SetAttribute("http.method", req.httpReq.Method)
+	span.SetAttribute("http.target", req.httpReq.URL.Path)
+	span.SetAttribute("http.status_code", req.HttpStatus)
+	span.SetAttribute("shop_id", req.ShopId)
+	span.SetAttribute("client_id", req.ClientId)
+	span.SetAttribute("class", req.Class)
+	span.SetAttribute("decision", req.Decision)
+	// The scopes the controllers throttle the request by, e.g. "shop_id:1"
+	var scopes []string
+	for _, scope := range RequestScopes(req) {
+		scopes = append(scopes, fmt.Sprintf("shop_id:%d", scope.ShopId))
+	}
+	span.SetAttribute("scopes", strings.Join(scopes, ","))
+	if req.Reason != "" {
+		span.SetAttribute("reason", req.Reason)
+	}
+	if req.RejectedBy != "" {
+		span.SetAttribute("rejected_by", req.RejectedBy)
+	}
+	if req.QueueOverflow != "" {
+		span.SetAttribute("queue_overflow", req.QueueOverflow)
+	}
+
+	if !req.QueuedAt.IsZero() {
+		dequeuedAt := req.QueuedAt.Add(req.QueueingTime)
+
+		queue := s.Tracer.StartSpan("queue", span.SpanContext(), req.QueuedAt)
+		queue.SetAttribute("queue_length", req.QueueLength)
+		queue.Finish(dequeuedAt)
+
+		worker := s.Tracer.StartSpan("worker", span.SpanContext(), dequeuedAt)
+		worker.SetAttribute("num_working", int(req.NumWorking))
+		worker.Finish(dequeuedAt.Add(req.ProcessingTime))
+	}
+
+	span.Finish(time.Now())
 }
@@ -0,0 +1,91 @@
+package platform
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metrics "github.com/armon/go-metrics"
+
+	"github.com/hkdsun/simiload/telemetry"
+)
+
+// Rejects every request of one shop
+type shopDenier struct {
+	shopId int
+}
+
+func (d shopDenier) AllowAccess(req *HttpRequest) bool {
+	return req.ShopId != d.shopId
+}
+
+func (d shopDenier) LogAccess(req *HttpRequest) {}
+
+func TestRequestCountByStatus(t *testing.T) {
+	sinks, err := telemetry.Setup(MetricService, telemetry.Config{Sinks: []string{telemetry.SinkInmem}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sinks.Close()
+
+	workers := &WorkerGroup{
+		NumWorkers:     1,
+		Handler:        DelayedResponder{},
+		MaxRPS:         1000,
+		QueueCapacity:  1,
+		OverflowPolicy: QueueReject,
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	workersWg := workers.Run(ctx)
+
+	sim := &Simulation{
+		Name:             "edge-0",
+		WorkerGroup:      workers,
+		AccessController: shopDenier{shopId: 2},
+		logQueue:         make(ReqQueue, 10),
+	}
+	serve := func(path string) int {
+		rec := httptest.NewRecorder()
+		sim.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	if code := serve("/shop/1/client/a"); code != http.StatusOK {
+		t.Errorf("admitted request answered %d", code)
+	}
+	if code := serve("/shop/2/client/a"); code != http.StatusTooManyRequests {
+		t.Errorf("rejected request answered %d", code)
+	}
+
+	stop()
+	workersWg.Wait()
+	if code := serve("/shop/1/client/a"); code != http.StatusServiceUnavailable {
+		t.Errorf("request after shutdown answered %d", code)
+	}
+
+	counts := make(map[string]float64)
+	for _, interval := range sinks.Inmem.Data() {
+		for _, counter := range interval.Counters {
+			if counter.Name != MetricService+"."+MetricRequestCount.Name {
+				continue
+			}
+			counts[labelValue(counter.Labels, "status")] += counter.Sum
+		}
+	}
+
+	for _, status := range []string{"200", "429", "503"} {
+		if counts[status] != 1 {
+			t.Errorf("counted %v requests with status %s, want 1", counts[status], status)
+		}
+	}
+}
+
+func labelValue(labels []metrics.Label, name string) string {
+	for _, label := range labels {
+		if label.Name == name {
+			return label.Value
+		}
+	}
+	return ""
+}
@@ -9,12 +9,30 @@ type Scope struct {
 }
 
 type Throttler struct {
-	Scope Scope
-	Rate  float32
+	Scope  Scope
+	Rate   float32
+	Origin string // edge that activated the throttler
+	Rand   *rand.Rand
+}
+
+type ThrottlerState struct {
+	Global bool    `json:"global,omitempty"`
+	ShopId int     `json:"shop_id,omitempty"`
+	Rate   float32 `json:"rate"` // share of requests rejected
+	Origin string  `json:"origin,omitempty"`
+}
+
+func (r *Throttler) State(global bool) ThrottlerState {
+	return ThrottlerState{
+		Global: global,
+		ShopId: r.Scope.ShopId,
+		Rate:   r.Rate,
+		Origin: r.Origin,
+	}
 }
 
 func (r *Throttler) Allow() bool {
-	return rand.Float32() > r.Rate
+	return float32(randFloat64(r.Rand)) > r.Rate
 }
 
 type ProThrottler struct {
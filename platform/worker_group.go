@@ -2,8 +2,8 @@ package platform
 
 import (
 	"context"
-	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,12 +12,37 @@ import (
 	"golang.org/x/time/rate"
 )
 
+// What happens to a request arriving at a full work queue
+const (
+	// Answer the new request with a 503
+	QueueReject = "reject"
+	// Answer a queued request with a 503 and queue the new one. The scheduler
+	// picks the request: the one that waited longest with SchedulerFIFO, the
+	// oldest of the lowest class with SchedulerPriority and the oldest of the
+	// shop with the longest queue for its weight with SchedulerDRR.
+	QueueDropOldest = "drop_oldest"
+	// Wait for room in the queue, up to QueueTimeout if it is set
+	QueueBlock = "block"
+)
+
+// How a request left a full work queue, see RequestStats.QueueOverflow
+const (
+	OverflowRejected = "rejected"
+	OverflowDropped  = "dropped"
+	OverflowTimedOut = "timed_out"
+)
+
+const DefaultQueueCapacity = 1000
+
+// How long QueueDropOldest waits before looking for a request to drop again
+// when the queue is full of requests that are yet to be queued
+const dropOldestBackoff = 1 * time.Millisecond
+
 type ReqQueue chan *HttpRequest
-type WorkQueue chan Work
 
 type Work struct {
 	Request  *HttpRequest
-	doneChan chan bool
+	doneChan chan bool // receives whether a worker served the request
 }
 
 // Simulates a limited capacity pool of workers
@@ -26,68 +51,270 @@ type WorkerGroup struct {
 	NumWorking uint32
 	Handler    http.Handler
 	MaxRPS     int
+	// Defaults to DefaultQueueCapacity requests, handled by QueueBlock once
+	// the queue is full
+	QueueCapacity  int
+	OverflowPolicy string
+	QueueTimeout   time.Duration // waiting forever when zero
+	// Order in which queued requests are served, SchedulerFIFO by default
+	Scheduler   string
+	ShopWeights map[int]int // for SchedulerDRR
 
-	workQueue WorkQueue
+	slots     chan struct{} // one per queued request, so the queue cannot grow past QueueCapacity
+	queue     scheduler
+	queueMut  sync.Mutex
+	queueCond *sync.Cond
+	stopping  bool // workers stop once set
+
+	stopped <-chan struct{}
+	mut     sync.RWMutex
+	closed  bool // no more work is queued once set
 }
 
-func (w *WorkerGroup) Serve(req *HttpRequest) {
+// Waits for a worker to serve the request. Returns false if the request
+// overflowed the work queue, or if the group stopped before serving it.
+func (w *WorkerGroup) Serve(req *HttpRequest) bool {
 	startQueueing := time.Now()
+	req.QueuedAt = startQueueing
 
-	<-w.serveReq(req)
+	doneChan, ok := w.serveReq(req)
+	served := ok && <-doneChan
 
 	req.TotalTime = time.Now().Sub(startQueueing)
 	req.QueueingTime = req.TotalTime - req.ProcessingTime
-	req.QueueLength = len(w.workQueue)
+	req.QueueLength = w.QueueLength()
 	req.NumWorking = atomic.LoadUint32(&w.NumWorking)
+
+	return served
+}
+
+func (w *WorkerGroup) QueueLength() int {
+	w.queueMut.Lock()
+	defer w.queueMut.Unlock()
+
+	return w.queue.len()
+}
+
+func (w *WorkerGroup) serveReq(req *HttpRequest) (chan bool, bool) {
+	w.mut.RLock()
+	defer w.mut.RUnlock()
+
+	if w.closed {
+		return nil, false
+	}
+
+	if !w.reserveSlot(req) {
+		return nil, false
+	}
+
+	doneChan := make(chan bool, 1)
+	w.queueMut.Lock()
+	w.queue.push(Work{req, doneChan})
+	w.queueCond.Signal()
+	w.queueMut.Unlock()
+
+	return doneChan, true
+}
+
+// Makes room for the request in the queue according to OverflowPolicy
+func (w *WorkerGroup) reserveSlot(req *HttpRequest) bool {
+	switch w.OverflowPolicy {
+	case QueueReject:
+		select {
+		case w.slots <- struct{}{}:
+			return true
+		default:
+			w.overflow(req, OverflowRejected)
+			return false
+		}
+	case QueueDropOldest:
+		for {
+			select {
+			case w.slots <- struct{}{}:
+				return true
+			default:
+			}
+
+			// The request takes over the slot of the one it replaces. The
+			// queue can be empty while a worker has yet to free its slot.
+			w.queueMut.Lock()
+			dropped, ok := w.queue.evict()
+			w.queueMut.Unlock()
+
+			if ok {
+				w.overflow(dropped.Request, OverflowDropped)
+				dropped.doneChan <- false
+				return true
+			}
+
+			// The slots are held by requests about to be queued. Wait for
+			// one to be queued or for a slot to free up rather than spin.
+			select {
+			case w.slots <- struct{}{}:
+				return true
+			case <-w.stopped:
+				return false
+			case <-time.After(dropOldestBackoff):
+			}
+		}
+	default:
+		var timeout <-chan time.Time
+		if w.QueueTimeout > 0 {
+			timer := time.NewTimer(w.QueueTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case w.slots <- struct{}{}:
+			return true
+		case <-timeout:
+			w.overflow(req, OverflowTimedOut)
+			return false
+		case <-w.stopped:
+			return false
+		}
+	}
 }
 
-func (w *WorkerGroup) serveReq(req *HttpRequest) chan bool {
-	doneChan := make(chan bool)
-	work := Work{req, doneChan}
-	w.workQueue <- work
-	return doneChan
+func (w *WorkerGroup) overflow(req *HttpRequest, outcome string) {
+	req.QueueOverflow = outcome
+	metrics.IncrCounterWithLabels(MetricQueueOverflow.Key(), 1, []metrics.Label{
+		{Name: "outcome", Value: outcome},
+		{Name: "shop_id", Value: strconv.Itoa(req.ShopId)},
+	})
 }
 
-func (w *WorkerGroup) Run() *sync.WaitGroup {
+// Waits for the next request to serve. Returns false once the workers stop.
+func (w *WorkerGroup) nextWork() (Work, bool) {
+	w.queueMut.Lock()
+	defer w.queueMut.Unlock()
+
+	for w.queue.len() == 0 && !w.stopping {
+		w.queueCond.Wait()
+	}
+	if w.stopping {
+		return Work{}, false
+	}
+
+	work, _ := w.queue.pop()
+	<-w.slots
+	return work, true
+}
+
+// Starts the workers. They stop once ctx is done, finishing the requests they
+// are serving; requests still queued then are not served. The WaitGroup is
+// done when every worker has stopped.
+func (w *WorkerGroup) Run(ctx context.Context) *sync.WaitGroup {
 	wg := &sync.WaitGroup{}
-	wg.Add(w.NumWorkers)
+	wg.Add(1)
+
+	if w.QueueCapacity <= 0 {
+		w.QueueCapacity = DefaultQueueCapacity
+	}
 
 	w.NumWorking = 0
-	w.workQueue = make(WorkQueue, 1000)
+	w.slots = make(chan struct{}, w.QueueCapacity)
+	w.queue = newScheduler(w.Scheduler, w.ShopWeights)
+	w.queueCond = sync.NewCond(&w.queueMut)
+	w.stopped = ctx.Done()
+
+	go func() {
+		<-ctx.Done()
+		w.queueMut.Lock()
+		w.stopping = true
+		w.queueCond.Broadcast()
+		w.queueMut.Unlock()
+	}()
+
+	workers := &sync.WaitGroup{}
+	workers.Add(w.NumWorkers + 2)
 
 	for id := 0; id < w.NumWorkers; id++ {
-		go w.consumeWorkQueue(w.workQueue, id)
+		go func(id int) {
+			defer workers.Done()
+			w.consumeWorkQueue(ctx, id)
+		}(id)
 	}
 
 	go func() {
+		defer workers.Done()
+
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+
 		for {
-			<-time.After(1 * time.Second)
-			metrics.SetGauge([]string{"workers.online"}, float32(w.NumWorkers))
-			metrics.SetGauge([]string{"workers.utilized"}, float32(atomic.LoadUint32(&w.NumWorking)))
+			select {
+			case <-ticker.C:
+				metrics.SetGauge(MetricWorkersOnline.Key(), float32(w.NumWorkers))
+				metrics.SetGauge(MetricWorkersUtilized.Key(), float32(atomic.LoadUint32(&w.NumWorking)))
+				metrics.SetGauge(MetricWorkersQueueLength.Key(), float32(w.QueueLength()))
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
+	// The distribution of busy workers, rather than a gauge per worker
+	go func() {
+		defer workers.Done()
+
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				metrics.AddSample(MetricWorkersBusy.Key(), float32(atomic.LoadUint32(&w.NumWorking)))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		workers.Wait()
+		w.rejectQueued()
+	}()
+
 	return wg
 }
 
-func (w *WorkerGroup) consumeWorkQueue(queue WorkQueue, id int) {
+// Answers the requests left in the queue once the workers have stopped
+func (w *WorkerGroup) rejectQueued() {
+	w.mut.Lock()
+	w.closed = true
+	w.mut.Unlock()
+
+	w.queueMut.Lock()
+	defer w.queueMut.Unlock()
+
+	for {
+		work, ok := w.queue.pop()
+		if !ok {
+			return
+		}
+		work.doneChan <- false
+	}
+}
+
+func (w *WorkerGroup) consumeWorkQueue(ctx context.Context, id int) {
 	limiter := rate.NewLimiter(rate.Limit(w.MaxRPS), 1)
 
 	for {
-		err := limiter.Wait(context.TODO())
-		if err != nil {
-			panic(err)
+		if err := limiter.Wait(ctx); err != nil {
+			return
 		}
 
-		metrics.IncrCounter([]string{"worker.pass"}, 1)
+		metrics.IncrCounter(MetricWorkerPass.Key(), 1)
 
-		work, ok := <-queue
+		work, ok := w.nextWork()
 		if !ok {
-			break
+			return
 		}
 
 		atomic.AddUint32(&w.NumWorking, 1)
-		metrics.SetGaugeWithLabels([]string{"workers.working"}, 1, []metrics.Label{{"id", fmt.Sprintf("%d", id)}})
 
 		req := work.Request
 
@@ -96,7 +323,6 @@ func (w *WorkerGroup) consumeWorkQueue(queue WorkQueue, id int) {
 		req.ProcessingTime = time.Now().Sub(start)
 
 		atomic.AddUint32(&w.NumWorking, ^uint32(0))
-		metrics.SetGaugeWithLabels([]string{"workers.working"}, 0, []metrics.Label{{"id", fmt.Sprintf("%d", id)}})
 
 		work.doneChan <- true
 	}
@@ -0,0 +1,113 @@
+package platform
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Serves requests only once released
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.started <- struct{}{}
+	<-h.release
+}
+
+type served struct {
+	req *HttpRequest
+	ok  bool
+}
+
+// Starts a single worker busy with one request and a second request waiting
+// in a queue of one, and returns the outcome of the queued request
+func fullWorkerGroup(t *testing.T, policy string) (*WorkerGroup, blockingHandler, <-chan served, func()) {
+	handler := blockingHandler{started: make(chan struct{}, 10), release: make(chan struct{})}
+	workers := &WorkerGroup{
+		NumWorkers:     1,
+		Handler:        handler,
+		MaxRPS:         1000,
+		QueueCapacity:  1,
+		OverflowPolicy: policy,
+		QueueTimeout:   20 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := workers.Run(ctx)
+
+	go workers.Serve(shopRequest(1))
+	<-handler.started
+
+	queued := make(chan served, 1)
+	go func() {
+		req := shopRequest(2)
+		queued <- served{req, workers.Serve(req)}
+	}()
+	if !eventually(func() bool { return workers.QueueLength() == 1 }) {
+		t.Fatal("the second request was not queued")
+	}
+
+	stop := func() {
+		close(handler.release)
+		cancel()
+		wg.Wait()
+	}
+	return workers, handler, queued, stop
+}
+
+func TestQueueRejectOverflow(t *testing.T) {
+	workers, _, _, stop := fullWorkerGroup(t, QueueReject)
+	defer stop()
+
+	req := shopRequest(3)
+	if workers.Serve(req) {
+		t.Error("a request past the queue's capacity was served")
+	}
+	if req.QueueOverflow != OverflowRejected {
+		t.Errorf("overflow %q, want %q", req.QueueOverflow, OverflowRejected)
+	}
+}
+
+func TestQueueDropOldestOverflow(t *testing.T) {
+	workers, handler, queued, stop := fullWorkerGroup(t, QueueDropOldest)
+
+	newest := make(chan served, 1)
+	go func() {
+		req := shopRequest(3)
+		newest <- served{req, workers.Serve(req)}
+	}()
+
+	dropped := <-queued
+	if dropped.ok || dropped.req.QueueOverflow != OverflowDropped {
+		t.Errorf("the queued request was served: %t with overflow %q, want it dropped", dropped.ok, dropped.req.QueueOverflow)
+	}
+
+	handler.release <- struct{}{}
+	<-handler.started
+	handler.release <- struct{}{}
+	if result := <-newest; !result.ok || result.req.QueueOverflow != "" {
+		t.Errorf("the newest request was served: %t with overflow %q", result.ok, result.req.QueueOverflow)
+	}
+
+	stop()
+}
+
+func TestQueueBlockOverflow(t *testing.T) {
+	workers, _, _, stop := fullWorkerGroup(t, QueueBlock)
+	defer stop()
+
+	start := time.Now()
+	req := shopRequest(3)
+	if workers.Serve(req) {
+		t.Error("a request was served while the queue was full")
+	}
+	if req.QueueOverflow != OverflowTimedOut {
+		t.Errorf("overflow %q, want %q", req.QueueOverflow, OverflowTimedOut)
+	}
+	if waited := time.Since(start); waited < workers.QueueTimeout {
+		t.Errorf("gave up after %v, before the queue timeout", waited)
+	}
+}
@@ -0,0 +1,129 @@
+package report
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+)
+
+const (
+	chartWidth   = 900
+	chartHeight  = 240
+	marginLeft   = 60
+	marginRight  = 20
+	marginTop    = 10
+	marginBottom = 30
+	yTicks       = 5
+)
+
+var palette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#17becf", "#bcbd22", "#393b79",
+}
+
+const otherColor = "#999999"
+
+type series struct {
+	Name   string
+	Color  string
+	Values []float64
+	Dashed bool
+}
+
+// A span of time highlighted on a chart, e.g. while a throttler was active
+type band struct {
+	From, To float64
+}
+
+// A line chart over the seconds since the start of the run
+type chart struct {
+	Title  string
+	Unit   string
+	Times  []float64
+	Series []series
+	Bands  []band
+}
+
+func (c chart) SVG() template.HTML {
+	plotWidth := float64(chartWidth - marginLeft - marginRight)
+	plotHeight := float64(chartHeight - marginTop - marginBottom)
+
+	maxTime := 1.0
+	if len(c.Times) > 0 && c.Times[len(c.Times)-1] > 0 {
+		maxTime = c.Times[len(c.Times)-1]
+	}
+	maxValue := 0.0
+	for _, s := range c.Series {
+		for _, v := range s.Values {
+			maxValue = math.Max(maxValue, v)
+		}
+	}
+	maxValue = niceCeiling(maxValue)
+
+	x := func(t float64) float64 { return marginLeft + t/maxTime*plotWidth }
+	y := func(v float64) float64 { return marginTop + plotHeight - v/maxValue*plotHeight }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, chartWidth, chartHeight, chartWidth, chartHeight)
+
+	for _, band := range c.Bands {
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%d" width="%.1f" height="%.0f" fill="#d62728" fill-opacity="0.12"/>`,
+			x(band.From), marginTop, math.Max(x(band.To)-x(band.From), 1), plotHeight)
+	}
+
+	for i := 0; i <= yTicks; i++ {
+		v := maxValue * float64(i) / yTicks
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#e5e5e5"/>`, marginLeft, y(v), marginLeft+plotWidth, y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`, marginLeft-6, y(v), formatValue(v))
+	}
+
+	for i := 0; i <= 10; i++ {
+		t := maxTime * float64(i) / 10
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%ss</text>`, x(t), chartHeight-marginBottom+18, formatValue(t))
+	}
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#333"/>`, marginLeft, y(0), marginLeft+plotWidth, y(0))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-weight="bold">%s</text>`, marginLeft+6, marginTop+12, html.EscapeString(c.Unit))
+
+	for _, s := range c.Series {
+		var points []string
+		for i, v := range s.Values {
+			if i >= len(c.Times) || math.IsNaN(v) {
+				continue
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(c.Times[i]), y(v)))
+		}
+		dash := ""
+		if s.Dashed {
+			dash = ` stroke-dasharray="6,4"`
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5"%s points="%s"><title>%s</title></polyline>`,
+			s.Color, dash, strings.Join(points, " "), html.EscapeString(s.Name))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// Rounds up to 1, 2 or 5 times a power of ten so that the axis ticks are
+// round numbers
+func niceCeiling(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if v <= step*magnitude {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.3g", v)
+}
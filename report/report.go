@@ -0,0 +1,402 @@
+// Package report renders a recorded simulation run as a self-contained HTML
+// page with inline SVG charts, so that it can be attached to a design review
+// without a metrics cluster.
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hkdsun/simiload/platform"
+)
+
+// Shops beyond the busiest ones are drawn together as "other"
+const DefaultShops = 8
+
+type shopTotals struct {
+	ShopId      string
+	Offered     int64
+	Admitted    int64
+	Shed        int64
+	ShedPercent float64
+}
+
+// A throttler that was active on an edge from Start to End
+type throttleEvent struct {
+	Scope   string
+	Origin  string
+	MaxRate float64
+	Start   float64
+	End     float64
+}
+
+type edgeReport struct {
+	Name   string
+	Shops  []shopTotals
+	Events []throttleEvent
+	Charts []chart
+}
+
+type page struct {
+	Recording platform.Recording
+	Duration  time.Duration
+	Edges     []edgeReport
+	Workers   chart
+}
+
+// Writes the recording as an HTML page, drawing the busiest shops of each
+// edge separately
+func Write(w io.Writer, recording platform.Recording, shops int) error {
+	times := make([]float64, len(recording.Intervals))
+	for i, interval := range recording.Intervals {
+		times[i] = interval.Time.Sub(recording.Start).Seconds()
+	}
+
+	p := page{
+		Recording: recording,
+		Duration:  recording.End.Sub(recording.Start).Round(time.Second),
+		Workers:   workersChart(recording, times),
+	}
+	for _, name := range edgeNames(recording) {
+		p.Edges = append(p.Edges, newEdgeReport(name, recording, times, shops))
+	}
+
+	return pageTemplate.Execute(w, p)
+}
+
+func WriteFile(path string, recording platform.Recording, shops int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := Write(file, recording, shops); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func edgeNames(recording platform.Recording) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, interval := range recording.Intervals {
+		for _, edge := range interval.Edges {
+			if !seen[edge.Name] {
+				seen[edge.Name] = true
+				names = append(names, edge.Name)
+			}
+		}
+	}
+	return names
+}
+
+// The edge's intervals, with nil where the edge did not report
+func edgeIntervals(name string, recording platform.Recording) []*platform.EdgeInterval {
+	intervals := make([]*platform.EdgeInterval, len(recording.Intervals))
+	for i := range recording.Intervals {
+		for j := range recording.Intervals[i].Edges {
+			if recording.Intervals[i].Edges[j].Name == name {
+				intervals[i] = &recording.Intervals[i].Edges[j]
+			}
+		}
+	}
+	return intervals
+}
+
+func newEdgeReport(name string, recording platform.Recording, times []float64, numShops int) edgeReport {
+	intervals := edgeIntervals(name, recording)
+	report := edgeReport{Name: name}
+
+	totals := make(map[int]*shopTotals)
+	for _, edge := range intervals {
+		if edge == nil {
+			continue
+		}
+		for shopId, counts := range edge.Shops {
+			t, ok := totals[shopId]
+			if !ok {
+				t = &shopTotals{ShopId: fmt.Sprint(shopId)}
+				totals[shopId] = t
+			}
+			t.Offered += counts.Offered
+			t.Admitted += counts.Admitted
+			t.Shed += counts.Shed
+		}
+	}
+
+	var shopIds []int
+	for shopId := range totals {
+		shopIds = append(shopIds, shopId)
+	}
+	sort.Slice(shopIds, func(i, j int) bool {
+		a, b := totals[shopIds[i]], totals[shopIds[j]]
+		if a.Offered != b.Offered {
+			return a.Offered > b.Offered
+		}
+		return shopIds[i] < shopIds[j]
+	})
+
+	for _, shopId := range shopIds {
+		t := totals[shopId]
+		if t.Offered > 0 {
+			t.ShedPercent = float64(t.Shed) / float64(t.Offered) * 100
+		}
+		report.Shops = append(report.Shops, *t)
+	}
+
+	drawn := shopIds
+	var others []int
+	if len(drawn) > numShops {
+		drawn, others = shopIds[:numShops], shopIds[numShops:]
+	}
+
+	report.Events = throttleEvents(intervals, times)
+	var bands []band
+	for _, event := range report.Events {
+		bands = append(bands, band{From: event.Start, To: event.End})
+	}
+
+	shopSeries := func(value func(platform.ShopCounts) int64, percentOfOffered bool) []series {
+		var result []series
+		add := func(label, color string, shops []int) {
+			s := series{Name: label, Color: color, Values: make([]float64, len(intervals))}
+			for i, edge := range intervals {
+				if edge == nil {
+					continue
+				}
+				var sum platform.ShopCounts
+				for _, shopId := range shops {
+					counts := edge.Shops[shopId]
+					sum.Offered += counts.Offered
+					sum.Admitted += counts.Admitted
+					sum.Shed += counts.Shed
+				}
+				if percentOfOffered {
+					if sum.Offered > 0 {
+						s.Values[i] = float64(value(sum)) / float64(sum.Offered) * 100
+					}
+				} else if seconds := recording.Intervals[i].Seconds; seconds > 0 {
+					s.Values[i] = float64(value(sum)) / seconds
+				}
+			}
+			result = append(result, s)
+		}
+
+		for i, shopId := range drawn {
+			add(fmt.Sprintf("shop %d", shopId), palette[i%len(palette)], []int{shopId})
+		}
+		if len(others) > 0 {
+			add("other", otherColor, others)
+		}
+		return result
+	}
+
+	offered := func(c platform.ShopCounts) int64 { return c.Offered }
+	admitted := func(c platform.ShopCounts) int64 { return c.Admitted }
+	shed := func(c platform.ShopCounts) int64 { return c.Shed }
+
+	report.Charts = []chart{
+		{Title: "Offered load", Unit: "req/s", Times: times, Series: shopSeries(offered, false), Bands: bands},
+		{Title: "Admitted load", Unit: "req/s", Times: times, Series: shopSeries(admitted, false), Bands: bands},
+		{Title: "Shed rate", Unit: "% of offered", Times: times, Series: shopSeries(shed, true), Bands: bands},
+		latencyChart(intervals, times, bands),
+	}
+	if load, ok := loadChart(intervals, times, bands); ok {
+		report.Charts = append(report.Charts, load)
+	}
+
+	return report
+}
+
+func latencyChart(intervals []*platform.EdgeInterval, times []float64, bands []band) chart {
+	percentiles := []struct {
+		name  string
+		value func(platform.LatencyPercentiles) float64
+	}{
+		{"p50", func(l platform.LatencyPercentiles) float64 { return l.P50 }},
+		{"p95", func(l platform.LatencyPercentiles) float64 { return l.P95 }},
+		{"p99", func(l platform.LatencyPercentiles) float64 { return l.P99 }},
+	}
+
+	c := chart{Title: "Latency of admitted requests", Unit: "ms", Times: times, Bands: bands}
+	for i, p := range percentiles {
+		s := series{Name: p.name, Color: palette[i], Values: make([]float64, len(intervals))}
+		for j, edge := range intervals {
+			if edge != nil {
+				s.Values[j] = p.value(edge.Latency)
+			}
+		}
+		c.Series = append(c.Series, s)
+	}
+	return c
+}
+
+// Every measured load the controller reports, with its limits dashed. P1's
+// threshold counts as a limit.
+func loadChart(intervals []*platform.EdgeInterval, times []float64, bands []band) (chart, bool) {
+	keys := make(map[string]bool)
+	for _, edge := range intervals {
+		if edge == nil {
+			continue
+		}
+		for key := range edge.Controller {
+			if strings.HasSuffix(key, "measured_load") || strings.HasSuffix(key, "local_load") ||
+				strings.HasSuffix(key, "soft_limit") || strings.HasSuffix(key, "hard_limit") || strings.HasSuffix(key, "threshold") {
+				keys[key] = true
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return chart{}, false
+	}
+
+	var sorted []string
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	c := chart{Title: "Measured load", Times: times, Bands: bands}
+	for i, key := range sorted {
+		s := series{
+			Name:   key,
+			Color:  palette[i%len(palette)],
+			Values: make([]float64, len(intervals)),
+			Dashed: !strings.HasSuffix(key, "_load"),
+		}
+		for j, edge := range intervals {
+			if edge != nil {
+				s.Values[j] = edge.Controller[key]
+			}
+		}
+		c.Series = append(c.Series, s)
+	}
+	return c, true
+}
+
+// Throttlers are identified by their scope. One that disappears and comes
+// back later makes two events.
+func throttleEvents(intervals []*platform.EdgeInterval, times []float64) []throttleEvent {
+	var events []throttleEvent
+	active := make(map[string]int) // scope to index in events
+
+	for i, edge := range intervals {
+		present := make(map[string]bool)
+		if edge != nil {
+			for _, t := range edge.Throttlers {
+				scope := fmt.Sprintf("shop %d", t.ShopId)
+				if t.Global {
+					scope = "global"
+				}
+				present[scope] = true
+
+				index, ok := active[scope]
+				if !ok {
+					start := 0.0
+					if i > 0 {
+						start = times[i-1]
+					}
+					events = append(events, throttleEvent{Scope: scope, Origin: t.Origin, Start: start})
+					index = len(events) - 1
+					active[scope] = index
+				}
+				if rate := float64(t.Rate) * 100; rate > events[index].MaxRate {
+					events[index].MaxRate = rate
+				}
+				events[index].End = times[i]
+			}
+		}
+
+		for scope := range active {
+			if !present[scope] {
+				delete(active, scope)
+			}
+		}
+	}
+
+	return events
+}
+
+func workersChart(recording platform.Recording, times []float64) chart {
+	busy := series{Name: "busy", Color: palette[0], Values: make([]float64, len(times))}
+	queue := series{Name: "queue length", Color: palette[1], Values: make([]float64, len(times))}
+	online := series{Name: "online", Color: otherColor, Values: make([]float64, len(times)), Dashed: true}
+
+	for i, interval := range recording.Intervals {
+		busy.Values[i] = float64(interval.Workers.Busy)
+		queue.Values[i] = float64(interval.Workers.QueueLength)
+		online.Values[i] = float64(interval.Workers.Online)
+	}
+
+	return chart{Title: "Workers", Times: times, Series: []series{busy, queue, online}}
+}
+
+var pageTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>simiload report: {{.Recording.Strategy}}</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 24px; color: #222; }
+h1 { font-size: 22px; }
+h2 { font-size: 18px; margin-top: 36px; border-bottom: 1px solid #ddd; }
+h3 { font-size: 14px; margin: 18px 0 4px; }
+svg text { font-size: 11px; fill: #555; }
+table { border-collapse: collapse; font-size: 13px; margin: 8px 0; }
+th, td { padding: 3px 12px; text-align: right; border-bottom: 1px solid #eee; }
+th:first-child, td:first-child { text-align: left; }
+.legend span { display: inline-block; margin-right: 14px; font-size: 12px; }
+.legend i { display: inline-block; width: 14px; height: 3px; margin-right: 4px; vertical-align: middle; }
+.note { color: #777; font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>simiload report</h1>
+<table>
+<tr><td>Strategy</td><td>{{.Recording.Strategy}}</td></tr>
+<tr><td>Seed</td><td>{{.Recording.Seed}}</td></tr>
+<tr><td>Start</td><td>{{.Recording.Start.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><td>Duration</td><td>{{.Duration}}</td></tr>
+<tr><td>Edges</td><td>{{len .Edges}}</td></tr>
+</table>
+
+{{define "chart"}}
+<h3>{{.Title}}</h3>
+{{.SVG}}
+<div class="legend">{{range .Series}}<span><i style="background: {{.Color}}"></i>{{.Name}}{{if .Dashed}} (dashed){{end}}</span>{{end}}</div>
+{{end}}
+
+{{template "chart" .Workers}}
+
+{{range .Edges}}
+<h2>{{.Name}}</h2>
+<p class="note">Shaded areas are times when a throttler was active.</p>
+{{range .Charts}}{{template "chart" .}}{{end}}
+
+<h3>Throttle events</h3>
+{{if .Events}}
+<table>
+<tr><th>Scope</th><th>Origin</th><th>Start</th><th>End</th><th>Max rejected</th></tr>
+{{range .Events}}<tr><td>{{.Scope}}</td><td>{{.Origin}}</td><td>{{printf "%.0fs" .Start}}</td><td>{{printf "%.0fs" .End}}</td><td>{{printf "%.0f%%" .MaxRate}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p class="note">No throttlers were active.</p>
+{{end}}
+
+<h3>Requests per shop</h3>
+<table>
+<tr><th>Shop</th><th>Offered</th><th>Admitted</th><th>Shed</th><th>Shed %</th></tr>
+{{range .Shops}}<tr><td>{{.ShopId}}</td><td>{{.Offered}}</td><td>{{.Admitted}}</td><td>{{.Shed}}</td><td>{{printf "%.1f" .ShedPercent}}</td></tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
@@ -1,16 +1,66 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	metrics "github.com/armon/go-metrics"
-	prom "github.com/armon/go-metrics/prometheus"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/hkdsun/simiload/platform"
+	"github.com/hkdsun/simiload/report"
+	"github.com/hkdsun/simiload/telemetry"
+	"github.com/hkdsun/simiload/tracing"
+)
+
+var (
+	loadControlStrategy = flag.String("strategy", "pro_num_workers", "load control strategy: none, p1, pro_queueing, pro_num_workers, rate_limit or chain")
+	seed                = flag.Int64("seed", time.Now().UnixNano(), "seed for all randomised controller decisions")
+	chainStages         = flag.String("chain", "rate_limit,p1", "comma separated strategies evaluated in order by the chain strategy")
+	shadowStrategy      = flag.String("shadow", "", "strategy evaluated in dry-run mode next to the active one")
+	shadowInterval      = flag.Duration("shadow-summary-interval", time.Minute, "how often the shadow comparison is printed")
+	accessLogPath       = flag.String("access-log", "", "file to write one JSON line per request to")
+	accessLogMaxSize    = flag.Int64("access-log-max-size", 100, "size in megabytes at which the access log is rotated")
+	accessLogBackups    = flag.Int("access-log-backups", 5, "number of rotated access logs to keep")
+	chainMode           = flag.String("chain-mode", platform.ChainFirstDeny, "chain semantics: first_deny, all_must_allow or shadow")
+	rateLimitConfig     = flag.String("rate-limit-config", "rate_limits.json", "per-shop token bucket config used by the rate_limit strategy")
+	port                = flag.Uint("port", 8080, "port of the first edge, further edges listen on every tenth port after it")
+	numEdges            = flag.Int("edges", 1, "number of edges sharing the worker group")
+	edgeName            = flag.String("edge-name", "edge", "prefix of the edge names used when sharing state")
+	stateBackend        = flag.String("state-backend", "none", "controller state sharing between edges: none, memory or gossip")
+	propagationDelay    = flag.Duration("propagation-delay", 0, "delay before shared controller state reaches other edges")
+	gossipAddr          = flag.String("gossip-addr", ":7946", "UDP address the gossip state backend listens on")
+	gossipPeers         = flag.String("gossip-peers", "", "comma separated UDP addresses of the peers to gossip with")
+	metricsSinks        = flag.String("metrics-sinks", "prometheus", "comma separated metrics sinks: prometheus, statsd, dogstatsd, inmem or file")
+	metricsAddr         = flag.String("metrics-addr", ":8081", "address serving /metrics for the prometheus sink and /inmem for the inmem sink")
+	statsdAddr          = flag.String("statsd-addr", "127.0.0.1:8125", "UDP address of the statsd and dogstatsd sinks")
+	metricsFile         = flag.String("metrics-file", "metrics.prom", "file the file sink appends snapshots to")
+	metricsFileInterval = flag.Duration("metrics-file-interval", 10*time.Second, "how often the file sink takes a snapshot")
+	traceExporter       = flag.String("trace-exporter", "none", "where request spans are exported: none, stdout or otlp")
+	otlpEndpoint        = flag.String("otlp-endpoint", "http://localhost:4318", "base URL of the OpenTelemetry collector for the otlp trace exporter")
+	traceSampleRate     = flag.Float64("trace-sample-rate", 1, "share of requests without a traceparent that are traced")
+	stateAddr           = flag.String("state-addr", ":8082", "address serving the live state read by top.go, empty to disable")
+	reportPath          = flag.String("report", "", "HTML report of the run written on SIGINT or SIGTERM")
+	queueCapacity       = flag.Int("queue-capacity", platform.DefaultQueueCapacity, "requests the work queue holds")
+	queuePolicy         = flag.String("queue-policy", platform.QueueBlock, "what happens to requests arriving at a full work queue: reject, drop_oldest or block")
+	queueTimeout        = flag.Duration("queue-timeout", 0, "how long the block queue policy waits for room in the queue, forever when zero")
+	scheduler           = flag.String("scheduler", platform.SchedulerFIFO, "order in which queued requests are served: fifo, priority (checkouts, then writes, then reads) or drr (fairly across shops)")
+	shopWeights         = flag.String("shop-weights", "", "comma separated shop_id=weight shares of the workers for the drr scheduler, 1 for unlisted shops, e.g. 1=4,2=2")
+	drainTimeout        = flag.Duration("drain-timeout", 10*time.Second, "how long requests in flight are given to finish on SIGINT or SIGTERM")
+	reportShops         = flag.Int("report-shops", report.DefaultShops, "busiest shops drawn separately in the report, the rest are drawn as other")
+	labelLimits         = flag.String("label-limits", "shop_id=20,client_id=20", "most frequent values kept per metric label, the rest are reported as other. Limits prefixed with a metric name and a colon replace the others for that metric, e.g. sim_request_count:class=5")
+	histogramBuckets    = flag.String("histogram-buckets", "", "comma separated upper bounds in milliseconds of the request time histogram buckets")
 )
 
 func main() {
@@ -22,30 +72,195 @@ func main() {
 	// -worker-response-time [duration]
 
 	// evaluationWindow := 10 * time.Second
+	flag.Parse()
+
+	log.WithField("seed", *seed).Info("Seeding controllers")
+	seeds = rand.New(rand.NewSource(*seed))
+
+	stateBus, err := newStateBus()
+	if err != nil {
+		log.WithError(err).Fatal("unable to start state backend")
+	}
+
+	switch *queuePolicy {
+	case platform.QueueReject, platform.QueueDropOldest, platform.QueueBlock:
+	default:
+		log.Fatalf("queue overflow policy %s not recognized", *queuePolicy)
+	}
+
+	switch *scheduler {
+	case platform.SchedulerFIFO, platform.SchedulerPriority, platform.SchedulerDRR:
+	default:
+		log.Fatalf("scheduler %s not recognized", *scheduler)
+	}
+
+	weights, err := parseShopWeights(*shopWeights)
+	if err != nil {
+		log.WithError(err).Fatal("invalid shop weights")
+	}
 
-	// loadControlStrategy := "none"
-	// loadControlStrategy := "pro_queueing"
-	loadControlStrategy := "pro_num_workers"
-	// loadControlStrategy := "p1"
+	workerGroup := &platform.WorkerGroup{
+		NumWorkers:     100,
+		Handler:        platform.DelayedResponder{ResponseTime: 100 * time.Millisecond},
+		MaxRPS:         20,
+		QueueCapacity:  *queueCapacity,
+		OverflowPolicy: *queuePolicy,
+		QueueTimeout:   *queueTimeout,
+		Scheduler:      *scheduler,
+		ShopWeights:    weights,
+	}
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	workerGroupWg := workerGroup.Run(workersCtx)
+
+	metricSinks := configureMetrics(workerGroup.NumWorkers)
+	tracer := newTracer()
+
+	var accessLog *platform.AccessLog
+	if *accessLogPath != "" {
+		accessLog, err = platform.NewAccessLog(*accessLogPath, *accessLogMaxSize*1024*1024, *accessLogBackups)
+		if err != nil {
+			log.WithError(err).Fatal("unable to open access log")
+		}
+	}
+
+	sims := make([]*platform.Simulation, *numEdges)
+	for i := range sims {
+		name := fmt.Sprintf("%s-%d", *edgeName, i)
+		accessController := newAccessController(*loadControlStrategy)
 
+		if *shadowStrategy != "" {
+			shadow := platform.NewShadowController(accessController, newAccessController(*shadowStrategy), *shadowStrategy)
+			go printShadowSummary(name, shadow)
+			accessController = shadow
+		}
+
+		if sharer, ok := accessController.(platform.StateSharer); ok && stateBus != nil {
+			sharer.ShareState(name, stateBus)
+		}
+
+		sims[i] = &platform.Simulation{
+			Name:                 name,
+			WorkerGroup:          workerGroup,
+			Port:                 *port + uint(10*i),
+			RequestSamplingDelay: 0 * time.Millisecond,
+			AccessController:     accessController,
+			AccessLog:            accessLog,
+			Tracer:               tracer,
+			DrainTimeout:         *drainTimeout,
+		}
+	}
+
+	monitor := &platform.Monitor{Simulations: sims, WorkerGroup: workerGroup}
+	if *stateAddr != "" {
+		go func() {
+			log.Infof("Serving live state on %s", *stateAddr)
+			if err := http.ListenAndServe(*stateAddr, monitor); err != nil {
+				log.WithError(err).Error("unable to serve live state")
+			}
+		}()
+	}
+
+	var recorder *platform.Recorder
+	if *reportPath != "" {
+		recorder = &platform.Recorder{
+			Monitor:  monitor,
+			Interval: 1 * time.Second,
+			Strategy: strategyName(),
+			Seed:     *seed,
+		}
+		for _, sim := range sims {
+			sim.Recorder = recorder
+		}
+		recorder.Start()
+	}
+
+	ctx, stopEdges := context.WithCancel(context.Background())
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		sig := <-sigs
+		log.Infof("Received %s, draining requests in flight", sig)
+		stopEdges()
+	}()
+
+	started := time.Now()
+	failed := make(chan *platform.Simulation, len(sims))
+
+	edgesWg := &sync.WaitGroup{}
+	for _, sim := range sims {
+		edgesWg.Add(1)
+		go func(sim *platform.Simulation) {
+			defer edgesWg.Done()
+			if err := sim.Run(ctx); err != nil {
+				log.WithError(err).Errorf("%s failed", sim.Name)
+				failed <- sim
+				stopEdges()
+			}
+		}(sim)
+	}
+	edgesWg.Wait()
+
+	// Requests still queued after the drain timeout are answered with a 503
+	stopWorkers()
+	workerGroupWg.Wait()
+	for _, sim := range sims {
+		sim.Close()
+	}
+
+	if accessLog != nil {
+		if err := accessLog.Close(); err != nil {
+			log.WithError(err).Error("unable to close access log")
+		}
+	}
+
+	if recorder != nil {
+		if err := report.WriteFile(*reportPath, recorder.Stop(), *reportShops); err != nil {
+			log.WithError(err).Error("unable to write report")
+		} else {
+			log.Infof("Wrote report to %s", *reportPath)
+		}
+	}
+
+	if closer, ok := stateBus.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.WithError(err).Error("unable to close state backend")
+		}
+	}
+
+	tracer.Close()
+	metricSinks.Close()
+
+	printSummary(monitor.Snapshot(), time.Since(started))
+	if len(failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Hands out a seed per controller so that a run is reproducible from -seed
+var seeds *rand.Rand
+
+func newAccessController(strategy string) platform.AccessController {
 	var accessController platform.AccessController
 
-	if loadControlStrategy == "none" {
-		accessController = &platform.DummyController{}
-	} else if loadControlStrategy == "p1" {
+	switch strategy {
+	case "none":
+		accessController = &platform.DummyController{
+			Rand: platform.NewRand(seeds.Int63()),
+		}
+	case "p1":
 		controller := &platform.ActiveController{}
 		analyzer := &platform.P1Controller{
 			QueueingTimeThreshold: 50 * time.Millisecond,
 			CircuitTimeout:        30 * time.Second,
 			AccessController:      accessController,
 			StatsEvaluator:        platform.NewSlidingWindowRequestCounter(60 * time.Second),
-			ActiveThrottlers:      make(map[platform.Scope]*platform.Throttler),
+			Rand:                  platform.NewRand(seeds.Int63()),
 			ThrottleStrategy:      "global",
 			// ThrottleStrategy:      "top_hitter",
 		}
 		controller.Analyzer = analyzer
 		accessController = controller
-	} else if loadControlStrategy == "pro_queueing" {
+	case "pro_queueing":
 		controller := &platform.ActiveController{}
 		analyzer := &platform.ProShed{
 			SoftLimit:        10, // queueing time
@@ -53,10 +268,11 @@ func main() {
 			AccessController: accessController,
 			LoadMut:          &sync.Mutex{},
 			LoadStrategy:     "queueing",
+			ProtectedClasses: []string{platform.ClassCheckout},
 		}
 		controller.Analyzer = analyzer
 		accessController = controller
-	} else if loadControlStrategy == "pro_num_workers" {
+	case "pro_num_workers":
 		controller := &platform.ActiveController{}
 		analyzer := &platform.ProShed{
 			SoftLimit:        90, // worker utilization
@@ -64,40 +280,202 @@ func main() {
 			AccessController: accessController,
 			LoadMut:          &sync.Mutex{},
 			LoadStrategy:     "num_working",
+			ProtectedClasses: []string{platform.ClassCheckout},
 		}
 		controller.Analyzer = analyzer
 		accessController = controller
+	case "rate_limit":
+		config, err := platform.LoadRateLimitConfig(*rateLimitConfig)
+		if err != nil {
+			log.WithError(err).Fatal("unable to load rate limit config")
+		}
+		controller, err := platform.NewRateLimitController(config)
+		if err != nil {
+			log.WithError(err).Fatal("invalid rate limit config")
+		}
+		accessController = controller
+	case "chain":
+		switch *chainMode {
+		case platform.ChainFirstDeny, platform.ChainAllMustAllow, platform.ChainShadow:
+		default:
+			log.Fatalf("chain mode %s not recognized", *chainMode)
+		}
+
+		controller := &platform.ChainController{
+			Mode: *chainMode,
+		}
+		for _, stage := range strings.Split(*chainStages, ",") {
+			if stage == "chain" {
+				log.Fatal("chain strategy cannot contain itself")
+			}
+			controller.Stages = append(controller.Stages, platform.ChainStage{
+				Name:       stage,
+				Controller: newAccessController(stage),
+			})
+		}
+		if *chainMode == platform.ChainShadow {
+			for _, stage := range controller.Stages[1:] {
+				platform.SetRole(stage.Controller, platform.RoleShadow)
+			}
+		}
+		accessController = controller
+	default:
+		log.Fatalf("load control strategy %s not recognized", strategy)
 	}
 
-	workerGroup := &platform.WorkerGroup{
-		NumWorkers: 100,
-		Handler:    platform.DelayedResponder{100 * time.Millisecond},
-		MaxRPS:     20,
+	return accessController
+}
+
+func printShadowSummary(edge string, shadow *platform.ShadowController) {
+	for range time.Tick(*shadowInterval) {
+		fmt.Printf("\nShadow %s vs %s on %s:\n", *shadowStrategy, *loadControlStrategy, edge)
+		shadow.WriteSummary(os.Stdout)
+	}
+}
+
+func strategyName() string {
+	name := *loadControlStrategy
+	if name == "chain" {
+		name = fmt.Sprintf("chain %s (%s)", *chainStages, *chainMode)
+	}
+	if *shadowStrategy != "" {
+		name += ", shadow " + *shadowStrategy
+	}
+	return name
+}
+
+func printSummary(snapshot platform.Snapshot, elapsed time.Duration) {
+	fmt.Printf("\nRan %s for %s with seed %d\n", strategyName(), elapsed.Round(time.Second), *seed)
+	for _, edge := range snapshot.Edges {
+		var total platform.ShopCounts
+		for _, counts := range edge.Shops {
+			total.Offered += counts.Offered
+			total.Admitted += counts.Admitted
+			total.Shed += counts.Shed
+			total.Overflowed += counts.Overflowed
+			total.Unserved += counts.Unserved
+		}
+
+		shed := 0.0
+		if total.Offered > 0 {
+			shed = float64(total.Shed) / float64(total.Offered) * 100
+		}
+		fmt.Printf("%s: %d requests from %d shops, %d admitted, %d shed (%.1f%%), %d overflowed the work queue, %d unserved at shutdown\n",
+			edge.Name, total.Offered, len(edge.Shops), total.Admitted, total.Shed, shed, total.Overflowed, total.Unserved)
 	}
-	workerGroupWg := workerGroup.Run()
-	defer workerGroupWg.Wait()
+}
 
-	sim := &platform.Simulation{
-		WorkerGroup:          workerGroup,
-		Port:                 8080,
-		RequestSamplingDelay: 0 * time.Millisecond,
-		AccessController:     accessController,
+func newTracer() *tracing.Tracer {
+	switch *traceExporter {
+	case "none":
+		return nil
+	case "stdout":
+		return tracing.NewTracer("simiload", &tracing.StdoutExporter{Writer: os.Stdout}, *traceSampleRate)
+	case "otlp":
+		return tracing.NewTracer("simiload", &tracing.OTLPExporter{Endpoint: *otlpEndpoint}, *traceSampleRate)
+	default:
+		log.Fatalf("trace exporter %s not recognized", *traceExporter)
+		return nil
 	}
+}
 
-	configureMetrics()
-	sim.Run()
+func newStateBus() (platform.StateBus, error) {
+	switch *stateBackend {
+	case "none":
+		return nil, nil
+	case "memory":
+		return platform.NewInMemoryStateBus(*propagationDelay), nil
+	case "gossip":
+		var peers []string
+		if *gossipPeers != "" {
+			peers = strings.Split(*gossipPeers, ",")
+		}
+		return platform.NewGossipStateBus(*gossipAddr, peers, *propagationDelay)
+	default:
+		return nil, fmt.Errorf("state backend %s not recognized", *stateBackend)
+	}
 }
 
-func configureMetrics() {
-	promSink, err := prom.NewPrometheusSink()
+func configureMetrics(numWorkers int) *telemetry.Telemetry {
+	buckets := telemetry.DefaultBuckets
+	if *histogramBuckets != "" {
+		var err error
+		if buckets, err = parseBuckets(*histogramBuckets); err != nil {
+			log.WithError(err).Fatal("invalid histogram buckets")
+		}
+	}
+
+	defaultLimits, metricLimits, err := telemetry.ParseLabelLimits(*labelLimits)
 	if err != nil {
-		panic(err)
+		log.WithError(err).Fatal("invalid label limits")
 	}
 
-	config := metrics.DefaultConfig("sim")
-	config.EnableHostname = false
-	metrics.NewGlobal(config, promSink)
+	var sinks []string
+	if *metricsSinks != "" {
+		sinks = strings.Split(*metricsSinks, ",")
+	}
+
+	service := platform.MetricService
+	t, err := telemetry.Setup(service, telemetry.Config{
+		Sinks: sinks,
+		Addr:  *metricsAddr,
+		Histograms: map[string][]float64{
+			platform.MetricRequestTotalTime.PrometheusName(service):      buckets,
+			platform.MetricRequestQueueingTime.PrometheusName(service):   buckets,
+			platform.MetricRequestProcessingTime.PrometheusName(service): buckets,
+			platform.MetricWorkersBusy.PrometheusName(service):           prometheus.LinearBuckets(0, float64(numWorkers)/10, 11),
+		},
+		StatsdAddr:    *statsdAddr,
+		FilePath:      *metricsFile,
+		FileInterval:  *metricsFileInterval,
+		DefaultLimits: defaultLimits,
+		MetricLimits:  metricLimits,
+	})
+	if err != nil {
+		log.WithError(err).Fatal("unable to set up metrics")
+	}
+
+	return t
+}
 
-	log.Info("Starting prometheus handler on port 8081")
-	go http.ListenAndServe(":8081", prometheus.Handler())
+func parseShopWeights(value string) (map[int]int, error) {
+	weights := make(map[int]int)
+	if value == "" {
+		return weights, nil
+	}
+
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected shop_id=weight, got %q", field)
+		}
+		shopId, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		weight, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if weight <= 0 {
+			return nil, fmt.Errorf("weight of shop %d must be positive", shopId)
+		}
+		weights[shopId] = weight
+	}
+	return weights, nil
+}
+
+func parseBuckets(value string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(value, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("bucket bounds must be increasing")
+		}
+		buckets = append(buckets, bound)
+	}
+	return buckets, nil
 }
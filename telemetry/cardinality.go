@@ -0,0 +1,229 @@
+package telemetry
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// Label value that the values outside of a label's top N are reported as
+const OtherValue = "other"
+
+const (
+	// How often the top values of each label are re-ranked
+	rankInterval = 1 * time.Second
+	// Weight kept by past observations at each ranking, which makes the
+	// ranking follow roughly the last ten seconds of traffic
+	rankDecay = 0.9
+)
+
+// Keeps the Limit most frequent values of a label and reports all others as
+// OtherValue. A Limit of zero reports every value as OtherValue.
+type LabelLimit struct {
+	Label string
+	Limit int
+}
+
+// Bounds the number of series a sink has to track by capping the distinct
+// values of high cardinality labels such as shop_id. Limits configured for a
+// metric, by exported name, replace the Default ones for that metric.
+type CardinalityGuard struct {
+	Sink    metrics.MetricSink
+	Default []LabelLimit
+	Metrics map[string][]LabelLimit
+
+	mut     sync.Mutex
+	rankers map[string]*labelRanker
+}
+
+// Parses limits such as "shop_id=20,client_id=0,sim_request_count:class=5",
+// where a metric name before the colon scopes the limit to that metric
+func ParseLabelLimits(value string) ([]LabelLimit, map[string][]LabelLimit, error) {
+	var defaults []LabelLimit
+	perMetric := make(map[string][]LabelLimit)
+
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		var metric string
+		if i := strings.Index(field, ":"); i >= 0 {
+			metric, field = field[:i], field[i+1:]
+		}
+
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, nil, fmt.Errorf("label limit %q is not of the form label=limit", field)
+		}
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit < 0 {
+			return nil, nil, fmt.Errorf("label limit %q must be a non-negative integer", field)
+		}
+
+		labelLimit := LabelLimit{Label: parts[0], Limit: limit}
+		if metric == "" {
+			defaults = append(defaults, labelLimit)
+		} else {
+			perMetric[metric] = append(perMetric[metric], labelLimit)
+		}
+	}
+
+	return defaults, perMetric, nil
+}
+
+func (g *CardinalityGuard) SetGauge(key []string, val float32) {
+	g.Sink.SetGauge(key, val)
+}
+
+func (g *CardinalityGuard) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	g.Sink.SetGaugeWithLabels(key, val, g.guard(key, labels))
+}
+
+func (g *CardinalityGuard) EmitKey(key []string, val float32) {
+	g.Sink.EmitKey(key, val)
+}
+
+func (g *CardinalityGuard) IncrCounter(key []string, val float32) {
+	g.Sink.IncrCounter(key, val)
+}
+
+func (g *CardinalityGuard) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	g.Sink.IncrCounterWithLabels(key, val, g.guard(key, labels))
+}
+
+func (g *CardinalityGuard) AddSample(key []string, val float32) {
+	g.Sink.AddSample(key, val)
+}
+
+func (g *CardinalityGuard) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	g.Sink.AddSampleWithLabels(key, val, g.guard(key, labels))
+}
+
+func (g *CardinalityGuard) guard(key []string, labels []metrics.Label) []metrics.Label {
+	name := flattenName(key)
+	limits, ok := g.Metrics[name]
+	if !ok {
+		limits = g.Default
+	}
+	if len(limits) == 0 {
+		return labels
+	}
+
+	var guarded []metrics.Label
+	for i, label := range labels {
+		for _, limit := range limits {
+			if label.Name != limit.Label {
+				continue
+			}
+
+			value := g.ranker(name, limit).observe(label.Value)
+			if value != label.Value {
+				if guarded == nil {
+					guarded = append([]metrics.Label(nil), labels...)
+				}
+				guarded[i].Value = value
+			}
+		}
+	}
+
+	if guarded == nil {
+		return labels
+	}
+	return guarded
+}
+
+func (g *CardinalityGuard) ranker(metric string, limit LabelLimit) *labelRanker {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if g.rankers == nil {
+		g.rankers = make(map[string]*labelRanker)
+	}
+
+	key := metric + ";" + limit.Label
+	r, ok := g.rankers[key]
+	if !ok {
+		r = &labelRanker{
+			limit:  limit.Limit,
+			counts: make(map[string]float64),
+			top:    make(map[string]bool),
+		}
+		g.rankers[key] = r
+	}
+	return r
+}
+
+// Tracks the most frequent recent values of one label of one metric
+type labelRanker struct {
+	limit int
+
+	mut      sync.Mutex
+	counts   map[string]float64
+	top      map[string]bool
+	lastRank time.Time
+}
+
+// Returns the value to report in place of the given one
+func (r *labelRanker) observe(value string) string {
+	if r.limit == 0 {
+		return OtherValue
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.counts[value]++
+
+	if time.Since(r.lastRank) >= rankInterval {
+		r.rank()
+	}
+
+	// Until the limit is reached values are admitted as they are seen
+	if !r.top[value] && len(r.top) < r.limit {
+		r.top[value] = true
+	}
+
+	if r.top[value] {
+		return value
+	}
+	return OtherValue
+}
+
+// Must be called with mut held
+func (r *labelRanker) rank() {
+	values := make([]string, 0, len(r.counts))
+	for value := range r.counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if r.counts[values[i]] != r.counts[values[j]] {
+			return r.counts[values[i]] > r.counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	r.top = make(map[string]bool, r.limit)
+	for i := 0; i < len(values) && i < r.limit; i++ {
+		r.top[values[i]] = true
+	}
+
+	// Forget values that have not been seen for a while, keeping the counts
+	// bounded by recent traffic
+	for value, count := range r.counts {
+		count *= rankDecay
+		if count < 0.5 {
+			delete(r.counts, value)
+		} else {
+			r.counts[value] = count
+		}
+	}
+
+	r.lastRank = time.Now()
+}
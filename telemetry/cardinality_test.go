@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// Records the labels of the last counter increment
+type labelRecorder struct {
+	metrics.BlackholeSink
+	labels []metrics.Label
+}
+
+func (r *labelRecorder) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	r.labels = labels
+}
+
+func (r *labelRecorder) value(name string) string {
+	for _, label := range r.labels {
+		if label.Name == name {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+func TestCardinalityGuardKeepsTopValues(t *testing.T) {
+	sink := &labelRecorder{}
+	guard := &CardinalityGuard{
+		Sink:    sink,
+		Default: []LabelLimit{{Label: "shop_id", Limit: 2}},
+	}
+
+	emit := func(shopId string) string {
+		guard.IncrCounterWithLabels([]string{"request", "count"}, 1, []metrics.Label{{Name: "shop_id", Value: shopId}})
+		return sink.value("shop_id")
+	}
+
+	for _, shopId := range []string{"1", "2"} {
+		if got := emit(shopId); got != shopId {
+			t.Errorf("shop %s reported as %s before the limit was reached", shopId, got)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if got := emit("3"); got != OtherValue {
+			t.Fatalf("shop 3 reported as %s past the limit", got)
+		}
+	}
+
+	// Shop 3 is now the busiest, and replaces one of the others once the
+	// values are ranked again
+	guard.ranker("request_count", LabelLimit{Label: "shop_id", Limit: 2}).lastRank = time.Time{}
+	if got := emit("3"); got != "3" {
+		t.Errorf("the busiest shop reported as %s after ranking", got)
+	}
+}
+
+func TestCardinalityGuardMetricLimits(t *testing.T) {
+	sink := &labelRecorder{}
+	guard := &CardinalityGuard{
+		Sink:    sink,
+		Default: []LabelLimit{{Label: "shop_id", Limit: 0}},
+		Metrics: map[string][]LabelLimit{"request_count": {{Label: "client_id", Limit: 0}}},
+	}
+
+	labels := []metrics.Label{{Name: "shop_id", Value: "1"}, {Name: "client_id", Value: "c"}}
+	guard.IncrCounterWithLabels([]string{"request", "count"}, 1, labels)
+
+	if sink.value("shop_id") != "1" || sink.value("client_id") != OtherValue {
+		t.Errorf("got %v, want only client_id limited", sink.labels)
+	}
+	if labels[1].Value != "c" {
+		t.Error("the caller's labels were modified")
+	}
+
+	guard.IncrCounterWithLabels([]string{"request", "dropped"}, 1, labels)
+	if sink.value("shop_id") != OtherValue || sink.value("client_id") != "c" {
+		t.Errorf("got %v, want the default limits for other metrics", sink.labels)
+	}
+}
+
+func TestParseLabelLimits(t *testing.T) {
+	defaults, perMetric, err := ParseLabelLimits("shop_id=20, client_id=0,sim_request_count:class=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []LabelLimit{{"shop_id", 20}, {"client_id", 0}}; !reflect.DeepEqual(defaults, want) {
+		t.Errorf("defaults %v, want %v", defaults, want)
+	}
+	if want := map[string][]LabelLimit{"sim_request_count": {{"class", 5}}}; !reflect.DeepEqual(perMetric, want) {
+		t.Errorf("per metric limits %v, want %v", perMetric, want)
+	}
+
+	for _, invalid := range []string{"shop_id", "=3", "shop_id=-1", "shop_id=many"} {
+		if _, _, err := ParseLabelLimits(invalid); err == nil {
+			t.Errorf("%q parsed without an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package telemetry
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_")
+
+// Sends each metric as a DogStatsD datagram, with labels as tags. Samples
+// are sent as histograms.
+type DogStatsdSink struct {
+	conn net.Conn
+}
+
+func NewDogStatsdSink(addr string) (*DogStatsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DogStatsdSink{conn: conn}, nil
+}
+
+func (s *DogStatsdSink) Close() error {
+	return s.conn.Close()
+}
+
+func (s *DogStatsdSink) SetGauge(key []string, val float32) {
+	s.send(key, val, "g", nil)
+}
+
+func (s *DogStatsdSink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.send(key, val, "g", labels)
+}
+
+func (s *DogStatsdSink) EmitKey(key []string, val float32) {
+	s.send(key, val, "g", nil)
+}
+
+func (s *DogStatsdSink) IncrCounter(key []string, val float32) {
+	s.send(key, val, "c", nil)
+}
+
+func (s *DogStatsdSink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.send(key, val, "c", labels)
+}
+
+func (s *DogStatsdSink) AddSample(key []string, val float32) {
+	s.send(key, val, "h", nil)
+}
+
+func (s *DogStatsdSink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.send(key, val, "h", labels)
+}
+
+func (s *DogStatsdSink) send(key []string, val float32, kind string, labels []metrics.Label) {
+	name := statsdEscaper.Replace(strings.Join(key, "."))
+	datagram := fmt.Sprintf("%s:%f|%s", name, val, kind)
+
+	if len(labels) > 0 {
+		tags := make([]string, len(labels))
+		for i, label := range labels {
+			tags[i] = statsdEscaper.Replace(label.Name) + ":" + statsdEscaper.Replace(label.Value)
+		}
+		datagram += "|#" + strings.Join(tags, ",")
+	}
+
+	// Metrics are best effort, like with the other statsd sinks
+	s.conn.Write([]byte(datagram))
+}
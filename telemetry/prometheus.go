@@ -0,0 +1,132 @@
+// Package telemetry holds the go-metrics sinks the simulator can report to.
+package telemetry
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	prom "github.com/armon/go-metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Bucket upper bounds for request times, in milliseconds
+var DefaultBuckets = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 25000}
+
+// Same expiry as the summaries, gauges and counters of the wrapped sink
+const histogramExpiration = 60 * time.Second
+
+// A Prometheus sink that exports the samples named in Histograms as
+// histograms rather than summaries, so that they can be aggregated across
+// label values and edges. Names are as exported, e.g. sim_request_total_time.
+type PrometheusSink struct {
+	*prom.PrometheusSink
+	Histograms map[string][]float64
+
+	mu         sync.Mutex
+	histograms map[string]prometheus.Histogram
+	updates    map[string]time.Time
+}
+
+func NewPrometheusSink(histograms map[string][]float64) (*PrometheusSink, error) {
+	sink, err := prom.NewPrometheusSink()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PrometheusSink{
+		PrometheusSink: sink,
+		Histograms:     histograms,
+		histograms:     make(map[string]prometheus.Histogram),
+		updates:        make(map[string]time.Time),
+	}
+
+	return s, prometheus.Register(histogramCollector{s})
+}
+
+func (s *PrometheusSink) AddSample(parts []string, val float32) {
+	s.AddSampleWithLabels(parts, val, nil)
+}
+
+func (s *PrometheusSink) AddSampleWithLabels(parts []string, val float32, labels []metrics.Label) {
+	name := flattenName(parts)
+	buckets, ok := s.Histograms[name]
+	if !ok {
+		s.PrometheusSink.AddSampleWithLabels(parts, val, labels)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := labelHash(name, labels)
+	h, ok := s.histograms[hash]
+	if !ok {
+		h = prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        name,
+			Help:        name,
+			Buckets:     buckets,
+			ConstLabels: prometheusLabels(labels),
+		})
+		s.histograms[hash] = h
+	}
+	h.Observe(float64(val))
+	s.updates[hash] = time.Now()
+}
+
+// Registered separately from the wrapped sink, which collects everything else
+type histogramCollector struct {
+	sink *PrometheusSink
+}
+
+func (c histogramCollector) Describe(ch chan<- *prometheus.Desc) {
+	// As for the wrapped sink, some description has to be emitted. It must
+	// differ from the wrapped sink's to be registered alongside it.
+	prometheus.NewGauge(prometheus.GaugeOpts{Name: "histograms_dummy", Help: "Dummy"}).Describe(ch)
+}
+
+func (c histogramCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.sink
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, h := range s.histograms {
+		if s.updates[hash].Add(histogramExpiration).Before(now) {
+			delete(s.histograms, hash)
+			delete(s.updates, hash)
+			continue
+		}
+		h.Collect(ch)
+	}
+}
+
+var forbiddenChars = regexp.MustCompile("[ .=\\-]")
+
+// Name of a metric as exported by the Prometheus sinks
+func flattenName(parts []string) string {
+	return forbiddenChars.ReplaceAllString(strings.Join(parts, "_"), "_")
+}
+
+func labelHash(name string, labels []metrics.Label) string {
+	sorted := append([]metrics.Label(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	hash := name
+	for _, label := range sorted {
+		hash += fmt.Sprintf(";%s=%s", label.Name, label.Value)
+	}
+	return hash
+}
+
+func prometheusLabels(labels []metrics.Label) prometheus.Labels {
+	l := make(prometheus.Labels)
+	for _, label := range labels {
+		l[label.Name] = label.Value
+	}
+	return l
+}
@@ -0,0 +1,104 @@
+package telemetry
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// Periodically appends everything a Prometheus gatherer holds to a file in
+// the Prometheus text format, so that headless runs can be analysed later.
+// Each snapshot starts with a "# snapshot <time>" comment and its samples
+// carry the snapshot's timestamp.
+type FileSnapshots struct {
+	Path     string
+	Interval time.Duration
+	Gatherer prometheus.Gatherer
+
+	mut    sync.Mutex
+	file   *os.File
+	ticker *time.Ticker
+	done   chan struct{}
+}
+
+func NewFileSnapshots(path string, interval time.Duration, gatherer prometheus.Gatherer) (*FileSnapshots, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("snapshot interval must be positive")
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileSnapshots{
+		Path:     path,
+		Interval: interval,
+		Gatherer: gatherer,
+		file:     file,
+		ticker:   time.NewTicker(interval),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case <-s.ticker.C:
+				if err := s.Snapshot(); err != nil {
+					log.WithError(err).Error("unable to write metrics snapshot")
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *FileSnapshots) Snapshot() error {
+	families, err := s.Gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return err
+	}
+
+	now := time.Now()
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	w := bufio.NewWriter(s.file)
+	fmt.Fprintf(w, "# snapshot %s\n", now.Format(time.RFC3339Nano))
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			metric.TimestampMs = &timestamp
+		}
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// Writes a final snapshot
+func (s *FileSnapshots) Close() error {
+	s.ticker.Stop()
+	close(s.done)
+
+	if err := s.Snapshot(); err != nil {
+		return err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.file.Close()
+}
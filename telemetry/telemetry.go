@@ -0,0 +1,188 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Scraped over HTTP at /metrics
+	SinkPrometheus = "prometheus"
+	// Plain statsd over UDP, labels are appended to the metric names
+	SinkStatsd = "statsd"
+	// Statsd over UDP with labels as DogStatsD tags
+	SinkDogStatsd = "dogstatsd"
+	// Kept in memory, served as JSON at /inmem and dumped to stderr on SIGUSR1
+	SinkInmem = "inmem"
+	// Snapshots in the Prometheus text format appended to a file
+	SinkFile = "file"
+)
+
+type Config struct {
+	Sinks []string
+
+	// Address of the HTTP server for the prometheus and inmem sinks
+	Addr string
+
+	// Samples exported as Prometheus histograms, see PrometheusSink
+	Histograms map[string][]float64
+
+	StatsdAddr string
+
+	FilePath     string
+	FileInterval time.Duration
+
+	// See CardinalityGuard
+	DefaultLimits []LabelLimit
+	MetricLimits  map[string][]LabelLimit
+}
+
+// The sinks configured by Setup
+type Telemetry struct {
+	// Set when the inmem sink is enabled, e.g. to assert on emitted metrics
+	Inmem *metrics.InmemSink
+
+	inmemSignal *metrics.InmemSignal
+	statsd      *metrics.StatsdSink
+	dogstatsd   *DogStatsdSink
+	snapshots   *FileSnapshots
+	server      *http.Server
+}
+
+// Creates the configured sinks and makes them the global go-metrics sink,
+// prefixing every metric with serviceName
+func Setup(serviceName string, config Config) (*Telemetry, error) {
+	t := &Telemetry{}
+	mux := http.NewServeMux()
+	serve := false
+
+	var sinks metrics.FanoutSink
+
+	// Shared by the prometheus and file sinks
+	var promSink *PrometheusSink
+	addPrometheusSink := func() error {
+		if promSink != nil {
+			return nil
+		}
+		var err error
+		if promSink, err = NewPrometheusSink(config.Histograms); err != nil {
+			return err
+		}
+		sinks = append(sinks, promSink)
+		return nil
+	}
+
+	for _, name := range config.Sinks {
+		switch name {
+		case SinkPrometheus:
+			if err := addPrometheusSink(); err != nil {
+				return nil, err
+			}
+			mux.Handle("/metrics", prometheus.Handler())
+			serve = true
+		case SinkStatsd:
+			sink, err := metrics.NewStatsdSink(config.StatsdAddr)
+			if err != nil {
+				return nil, err
+			}
+			t.statsd = sink
+			sinks = append(sinks, sink)
+		case SinkDogStatsd:
+			sink, err := NewDogStatsdSink(config.StatsdAddr)
+			if err != nil {
+				return nil, err
+			}
+			t.dogstatsd = sink
+			sinks = append(sinks, sink)
+		case SinkInmem:
+			t.Inmem = metrics.NewInmemSink(10*time.Second, time.Minute)
+			t.inmemSignal = metrics.DefaultInmemSignal(t.Inmem)
+			sinks = append(sinks, t.Inmem)
+			mux.HandleFunc("/inmem", t.serveInmem)
+			serve = true
+		case SinkFile:
+			if err := addPrometheusSink(); err != nil {
+				return nil, err
+			}
+			snapshots, err := NewFileSnapshots(config.FilePath, config.FileInterval, prometheus.DefaultGatherer)
+			if err != nil {
+				return nil, err
+			}
+			t.snapshots = snapshots
+		default:
+			return nil, fmt.Errorf("metrics sink %s not recognized", name)
+		}
+	}
+
+	if serve && config.Addr != "" {
+		listener, err := net.Listen("tcp", config.Addr)
+		if err != nil {
+			return nil, err
+		}
+
+		t.server = &http.Server{Handler: mux}
+		go t.server.Serve(listener)
+		log.WithField("addr", config.Addr).Info("Serving metrics")
+	}
+
+	var sink metrics.MetricSink = &metrics.BlackholeSink{}
+	if len(sinks) == 1 {
+		sink = sinks[0]
+	} else if len(sinks) > 1 {
+		sink = sinks
+	}
+
+	if len(config.DefaultLimits) > 0 || len(config.MetricLimits) > 0 {
+		sink = &CardinalityGuard{
+			Sink:    sink,
+			Default: config.DefaultLimits,
+			Metrics: config.MetricLimits,
+		}
+	}
+
+	metricsConfig := metrics.DefaultConfig(serviceName)
+	metricsConfig.EnableHostname = false
+	if _, err := metrics.NewGlobal(metricsConfig, sink); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Writes a last file snapshot and stops the sinks
+func (t *Telemetry) Close() error {
+	if t.server != nil {
+		t.server.Close()
+	}
+	if t.inmemSignal != nil {
+		t.inmemSignal.Stop()
+	}
+	if t.statsd != nil {
+		t.statsd.Shutdown()
+	}
+	if t.dogstatsd != nil {
+		t.dogstatsd.Close()
+	}
+	if t.snapshots != nil {
+		return t.snapshots.Close()
+	}
+	return nil
+}
+
+func (t *Telemetry) serveInmem(w http.ResponseWriter, r *http.Request) {
+	summary, err := t.Inmem.DisplayMetrics(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
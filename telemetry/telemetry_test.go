@@ -0,0 +1,85 @@
+package telemetry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInmemSink(t *testing.T) {
+	sinks, err := Setup("sim", Config{Sinks: []string{SinkInmem}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sinks.Close()
+
+	if sinks.Inmem == nil {
+		t.Fatal("the inmem sink is not exposed")
+	}
+
+	metrics.IncrCounterWithLabels([]string{"request", "count"}, 1, []metrics.Label{{Name: "status", Value: "429"}})
+	metrics.IncrCounterWithLabels([]string{"request", "count"}, 2, []metrics.Label{{Name: "status", Value: "429"}})
+
+	var sum float64
+	for _, interval := range sinks.Inmem.Data() {
+		for _, counter := range interval.Counters {
+			if counter.Name == "sim.request.count" && len(counter.Labels) == 1 && counter.Labels[0].Value == "429" {
+				sum += counter.Sum
+			}
+		}
+	}
+	if sum != 3 {
+		t.Errorf("counted %v, want 3", sum)
+	}
+}
+
+func TestSetupRejectsUnknownSinks(t *testing.T) {
+	if _, err := Setup("sim", Config{Sinks: []string{"graphite"}}); err == nil {
+		t.Error("expected an error for an unknown sink")
+	}
+}
+
+func TestFileSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "sim_request_count", Help: "Requests"})
+	registry.MustRegister(counter)
+	counter.Add(7)
+
+	path := filepath.Join(dir, "metrics.prom")
+	snapshots, err := NewFileSnapshots(path, time.Hour, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshots.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	counter.Add(1)
+	if err := snapshots.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+
+	if n := strings.Count(text, "# snapshot "); n != 2 {
+		t.Errorf("wrote %d snapshots, want one and a final one on Close", n)
+	}
+	if !strings.Contains(text, "sim_request_count 7 ") || !strings.Contains(text, "sim_request_count 8 ") {
+		t.Errorf("snapshots do not hold the counter's values:\n%s", text)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hkdsun/simiload/platform"
+)
+
+var (
+	stateURL = flag.String("url", "http://localhost:8082/state", "address of the simulator's live state, see server.go -state-addr")
+	interval = flag.Duration("interval", time.Second, "refresh interval")
+	numShops = flag.Int("shops", 15, "number of busiest shops shown per edge")
+)
+
+const (
+	barWidth = 40
+
+	clearScreen = "\x1b[H\x1b[2J"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+	bold        = "\x1b[1m"
+	red         = "\x1b[31m"
+	green       = "\x1b[32m"
+	yellow      = "\x1b[33m"
+	reset       = "\x1b[0m"
+)
+
+type shopRates struct {
+	shopId                  int
+	offered, admitted, shed float64
+}
+
+func main() {
+	flag.Parse()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+
+	fmt.Print(hideCursor)
+	defer fmt.Print(showCursor)
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	var previous *platform.Snapshot
+	for {
+		snapshot, err := fetchSnapshot(client)
+
+		var screen bytes.Buffer
+		render(&screen, previous, snapshot, err)
+		os.Stdout.Write(screen.Bytes())
+
+		if err == nil {
+			previous = snapshot
+		}
+
+		select {
+		case <-ticker.C:
+		case <-sigs:
+			fmt.Println()
+			return
+		}
+	}
+}
+
+func fetchSnapshot(client *http.Client) (*platform.Snapshot, error) {
+	resp, err := client.Get(*stateURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %s", *stateURL, resp.Status)
+	}
+
+	snapshot := &platform.Snapshot{}
+	if err := json.NewDecoder(resp.Body).Decode(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func render(w io.Writer, previous, current *platform.Snapshot, err error) {
+	fmt.Fprint(w, clearScreen)
+	fmt.Fprintf(w, "%ssimiload top%s  %s  %s  (Ctrl-C to quit)\n\n", bold, reset, *stateURL, time.Now().Format("15:04:05"))
+
+	if err != nil {
+		fmt.Fprintf(w, "%sunable to read state: %v%s\n", red, err, reset)
+		return
+	}
+
+	workers := current.Workers
+	fmt.Fprintf(w, "%-16s %s %d/%d busy  queue %d\n\n", "workers",
+		bar(float64(workers.Busy), float64(workers.Online), 0, 0), workers.Busy, workers.Online, workers.QueueLength)
+
+	for _, edge := range current.Edges {
+		var previousEdge *platform.EdgeState
+		if previous != nil {
+			for i := range previous.Edges {
+				if previous.Edges[i].Name == edge.Name {
+					previousEdge = &previous.Edges[i]
+				}
+			}
+		}
+
+		renderEdge(w, edge, previousEdge, current.Time.Sub(previousTime(previous)))
+		fmt.Fprintln(w)
+	}
+}
+
+func previousTime(previous *platform.Snapshot) time.Time {
+	if previous == nil {
+		return time.Time{}
+	}
+	return previous.Time
+}
+
+func renderEdge(w io.Writer, edge platform.EdgeState, previous *platform.EdgeState, elapsed time.Duration) {
+	fmt.Fprintf(w, "%s%s%s\n", bold, edge.Name, reset)
+
+	// Loads are shown against the limits reported next to them, e.g. a
+	// chain stage's p1.measured_load against p1.soft_limit
+	keys := make([]string, 0, len(edge.Controller))
+	for key := range edge.Controller {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var others []string
+	for _, key := range keys {
+		value := edge.Controller[key]
+		if strings.HasSuffix(key, "soft_limit") || strings.HasSuffix(key, "hard_limit") || strings.HasSuffix(key, "threshold") {
+			continue
+		}
+		if !strings.HasSuffix(key, "measured_load") {
+			others = append(others, fmt.Sprintf("%s %g", key, value))
+			continue
+		}
+
+		// P1 has a single threshold, treated as a hard limit
+		prefix := strings.TrimSuffix(key, "measured_load")
+		soft := edge.Controller[prefix+"soft_limit"]
+		hard, ok := edge.Controller[prefix+"hard_limit"]
+		limits := fmt.Sprintf("  soft %g  hard %g", soft, hard)
+		if !ok {
+			hard = edge.Controller[prefix+"threshold"]
+			limits = fmt.Sprintf("  threshold %g", hard)
+		}
+
+		if hard <= 0 {
+			fmt.Fprintf(w, "  %-14s %.2f\n", key, value)
+			continue
+		}
+		fmt.Fprintf(w, "  %-14s %s %.2f%s\n", key, bar(value, hard*1.2, soft, hard), value, limits)
+	}
+	if len(others) > 0 {
+		fmt.Fprintf(w, "  %s\n", strings.Join(others, "  "))
+	}
+
+	if len(edge.Throttlers) > 0 {
+		var throttlers []string
+		for _, t := range edge.Throttlers {
+			scope := fmt.Sprintf("shop %d", t.ShopId)
+			if t.Global {
+				scope = "global"
+			}
+			throttlers = append(throttlers, fmt.Sprintf("%s%s rejecting %.0f%% (from %s)%s", red, scope, t.Rate*100, t.Origin, reset))
+		}
+		fmt.Fprintf(w, "  throttlers: %s\n", strings.Join(throttlers, ", "))
+	}
+
+	rates := shopRatesOf(edge, previous, elapsed)
+	if len(rates) > *numShops {
+		rates = rates[:*numShops]
+	}
+
+	fmt.Fprintf(w, "  %-8s %12s %12s %12s %8s\n", "shop", "offered/s", "admitted/s", "shed/s", "shed")
+	for _, r := range rates {
+		shed := 0.0
+		if r.offered > 0 {
+			shed = r.shed / r.offered * 100
+		}
+
+		color := ""
+		if shed > 0 {
+			color = yellow
+		}
+		fmt.Fprintf(w, "  %-8d %12.1f %12.1f %s%12.1f %7.0f%%%s\n", r.shopId, r.offered, r.admitted, color, r.shed, shed, reset)
+	}
+}
+
+// Busiest shops first. Without a previous snapshot there is nothing to
+// compare to, so every rate is zero.
+func shopRatesOf(edge platform.EdgeState, previous *platform.EdgeState, elapsed time.Duration) []shopRates {
+	var rates []shopRates
+	for shopId, counts := range edge.Shops {
+		r := shopRates{shopId: shopId}
+
+		if previous != nil && elapsed > 0 {
+			before := previous.Shops[shopId]
+			seconds := elapsed.Seconds()
+			r.offered = float64(counts.Offered-before.Offered) / seconds
+			r.admitted = float64(counts.Admitted-before.Admitted) / seconds
+			r.shed = float64(counts.Shed-before.Shed) / seconds
+		}
+		rates = append(rates, r)
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].offered != rates[j].offered {
+			return rates[i].offered > rates[j].offered
+		}
+		return rates[i].shopId < rates[j].shopId
+	})
+	return rates
+}
+
+// A horizontal bar of value out of max, coloured by the soft and hard limits
+// when they are given
+func bar(value, max, soft, hard float64) string {
+	filled := 0
+	if max > 0 {
+		filled = int(value / max * barWidth)
+	}
+	if filled > barWidth {
+		filled = barWidth
+	}
+	if filled < 0 {
+		filled = 0
+	}
+
+	color := green
+	if hard > 0 && value >= hard {
+		color = red
+	} else if soft > 0 && value >= soft {
+		color = yellow
+	}
+
+	return fmt.Sprintf("[%s%s%s%s]", color, strings.Repeat("#", filled), reset, strings.Repeat(".", barWidth-filled))
+}
@@ -0,0 +1,104 @@
+// Package tracing records the lifecycle of simulated requests as spans and
+// exports them to stdout or to an OpenTelemetry collector over OTLP/HTTP.
+// Trace context is propagated with W3C traceparent headers.
+package tracing
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+const TraceparentHeader = "traceparent"
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+// Identifies a span across processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", c.TraceID, c.SpanID, flags)
+}
+
+// Parses a version 00 traceparent header
+func ParseTraceparent(header string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return SpanContext{}, fmt.Errorf("unsupported traceparent %q", header)
+	}
+
+	var c SpanContext
+	if err := decodeHex(parts[1], c.TraceID[:]); err != nil {
+		return SpanContext{}, fmt.Errorf("traceparent trace id: %v", err)
+	}
+	if err := decodeHex(parts[2], c.SpanID[:]); err != nil {
+		return SpanContext{}, fmt.Errorf("traceparent parent id: %v", err)
+	}
+
+	var flags [1]byte
+	if err := decodeHex(parts[3], flags[:]); err != nil {
+		return SpanContext{}, fmt.Errorf("traceparent flags: %v", err)
+	}
+	c.Sampled = flags[0]&1 == 1
+
+	if !c.IsValid() {
+		return SpanContext{}, fmt.Errorf("traceparent %q has a zero id", header)
+	}
+	return c, nil
+}
+
+func decodeHex(s string, into []byte) error {
+	if len(s) != 2*len(into) {
+		return fmt.Errorf("expected %d hex digits, got %q", 2*len(into), s)
+	}
+	_, err := hex.Decode(into, []byte(s))
+	return err
+}
+
+// A span context starting a new trace, e.g. for a request sent by the load
+// generator
+func NewRootContext(sampled bool) SpanContext {
+	return SpanContext{
+		TraceID: newTraceID(),
+		SpanID:  newSpanID(),
+		Sampled: sampled,
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
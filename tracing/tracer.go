@@ -0,0 +1,196 @@
+package tracing
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	batchSize     = 512
+	batchInterval = 1 * time.Second
+	// Spans finished while this many are waiting to be exported are dropped
+	queueSize = 10000
+)
+
+// What a span represents, see the OpenTelemetry span kinds
+const (
+	SpanKindInternal = "internal"
+	// The handling of a request received from a client, whether or not the
+	// client sent a traceparent
+	SpanKindServer = "server"
+)
+
+type Span struct {
+	Name       string
+	Kind       string // SpanKindInternal when empty
+	Context    SpanContext
+	Parent     SpanID // zero for the root of a trace
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+
+	tracer *Tracer
+}
+
+// Attribute values are strings, bools, ints or float64s
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+func (s *Span) SetKind(kind string) {
+	if s == nil {
+		return
+	}
+	s.Kind = kind
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// Ends the span at the given time and queues it for export. Unsampled spans
+// are discarded.
+func (s *Span) Finish(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.End = end
+
+	if s.Context.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Creates spans and exports them in batches. A nil *Tracer creates nil
+// spans, on which every method is a no-op, so tracing can be left disabled.
+type Tracer struct {
+	Service  string
+	Exporter Exporter
+	// Share of new traces that are sampled. Traces continued from a
+	// traceparent keep its sampling decision.
+	SampleRate float64
+
+	queue  chan *Span
+	done   chan struct{}
+	mut    sync.RWMutex
+	closed bool
+}
+
+func NewTracer(service string, exporter Exporter, sampleRate float64) *Tracer {
+	t := &Tracer{
+		Service:    service,
+		Exporter:   exporter,
+		SampleRate: sampleRate,
+		queue:      make(chan *Span, queueSize),
+		done:       make(chan struct{}),
+	}
+
+	go t.exportBatches()
+	return t
+}
+
+// Starts a span that continues the parent's trace, or a new trace if the
+// parent is not valid
+func (t *Tracer) StartSpan(name string, parent SpanContext, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+
+	span := &Span{
+		Name:       name,
+		Start:      start,
+		Attributes: make(map[string]interface{}),
+		tracer:     t,
+	}
+
+	if parent.IsValid() {
+		span.Context = SpanContext{
+			TraceID: parent.TraceID,
+			SpanID:  newSpanID(),
+			Sampled: parent.Sampled,
+		}
+		span.Parent = parent.SpanID
+	} else {
+		span.Context = NewRootContext(rand.Float64() < t.SampleRate)
+	}
+
+	return span
+}
+
+// Exports the spans that are still queued
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+
+	t.mut.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mut.Unlock()
+
+	<-t.done
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	if t.closed {
+		return
+	}
+
+	select {
+	case t.queue <- span:
+	default:
+		log.Warn("Trace export queue is full, dropping span")
+	}
+}
+
+func (t *Tracer) exportBatches() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.Exporter.Export(batch); err != nil {
+			log.WithError(err).Error("unable to export spans")
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case span, ok := <-t.queue:
+			if !ok {
+				export()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		}
+	}
+}